@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -23,7 +30,15 @@ import (
 	"github.com/music-queue-system/pkg/redis"
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish once
+// the server is asked to stop.
+const shutdownTimeout = 15 * time.Second
+
 func main() {
+	// Stop background work and the server on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Printf("Warning: .env file not found")
@@ -53,11 +68,24 @@ func main() {
 		DB:       0,
 	})
 
+	// Every instance must see every room event to fan it out to its own
+	// WebSocket clients, so each one reads in a consumer group of its own
+	// rather than sharing partitions with the others. The group is named
+	// after the instance so restarts reuse it instead of leaving old ones
+	// behind on the broker.
+	instance := os.Getenv("INSTANCE_NAME")
+	if instance == "" {
+		if instance, err = os.Hostname(); err != nil {
+			log.Fatalf("Failed to get hostname, set INSTANCE_NAME: %v", err)
+		}
+	}
+	groupID := fmt.Sprintf("%s-%s", os.Getenv("KAFKA_GROUP_ID"), instance)
+
 	// Initialize Kafka client – writer without fixed topic
 	kafkaClient := events.NewKafkaClient(
 		strings.Split(os.Getenv("KAFKA_BROKERS"), ","),
 		"music-queue-events", // reader subscribes to this umbrella topic
-		os.Getenv("KAFKA_GROUP_ID"),
+		groupID,
 	)
 
 	// Initialize services
@@ -68,14 +96,78 @@ func main() {
 	)
 
 	tokenStore := redis.NewTokenStore(redisClient)
-	roomService := room.NewService(db, redisClient, kafkaClient)
+	roomService := room.NewService(db, redisClient, kafkaClient, spotifyClient)
+
+	playerService := player.NewService(spotifyClient, tokenStore)
+	playbackDriver := player.NewDriver(roomService, playerService, redisClient)
+
+	// Advance rooms whose current track has finished and play them on the
+	// hosts' devices
+	go roomService.RunPlaybackClock(ctx)
+	go playbackDriver.Run(ctx)
+
+	// Repair denormalized vote totals that drifted from the votes table
+	go roomService.RunVoteReconciler(ctx, time.Hour)
+
+	// WebSocket delivery settings
+	wsConfig := ws.DefaultConfig()
+	if v := os.Getenv("WS_SEND_QUEUE_SIZE"); v != "" {
+		if wsConfig.SendQueueSize, err = strconv.Atoi(v); err != nil || wsConfig.SendQueueSize < 1 {
+			log.Fatalf("Invalid WS_SEND_QUEUE_SIZE: %q", v)
+		}
+	}
+	if v := os.Getenv("WS_SLOW_CONSUMER_POLICY"); v != "" {
+		if wsConfig.SlowConsumerPolicy, err = ws.ParseSlowConsumerPolicy(v); err != nil {
+			log.Fatalf("Invalid WS_SLOW_CONSUMER_POLICY: %v", err)
+		}
+	}
+	if v := os.Getenv("WS_WRITE_TIMEOUT"); v != "" {
+		if wsConfig.WriteTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid WS_WRITE_TIMEOUT: %v", err)
+		}
+	}
+	if v := os.Getenv("WS_PING_INTERVAL"); v != "" {
+		if wsConfig.PingInterval, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid WS_PING_INTERVAL: %v", err)
+		}
+	}
+	if v := os.Getenv("WS_PONG_WAIT"); v != "" {
+		if wsConfig.PongWait, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid WS_PONG_WAIT: %v", err)
+		}
+	}
+	if wsConfig.PingInterval >= wsConfig.PongWait {
+		log.Fatalf("WS_PING_INTERVAL must be shorter than WS_PONG_WAIT")
+	}
+	if v := os.Getenv("WS_MAX_MESSAGE_SIZE"); v != "" {
+		if wsConfig.MaxMessageSize, err = strconv.ParseInt(v, 10, 64); err != nil || wsConfig.MaxMessageSize < 1 {
+			log.Fatalf("Invalid WS_MAX_MESSAGE_SIZE: %q", v)
+		}
+	}
 
 	// Initialize handlers
 	authHandler := auth.NewHandler(spotifyClient, tokenStore)
 	roomHandler := room.NewHandler(roomService)
-	wsHandler := ws.NewHandler(kafkaClient)
-	playerHandler := player.NewHandler(spotifyClient, tokenStore)
-	searchHandler := search.NewHandler(spotifyClient)
+	wsHandler := ws.NewHandler(kafkaClient, roomService, wsConfig)
+	playerHandler := player.NewHandler(playerService)
+	searchHandler := search.NewHandler(spotifyClient, playerService, redisClient)
+
+	// Close rooms nobody has used or been connected to for a while
+	idleTimeout := 2 * time.Hour
+	if v := os.Getenv("ROOM_IDLE_TIMEOUT"); v != "" {
+		if idleTimeout, err = time.ParseDuration(v); err != nil {
+			log.Fatalf("Invalid ROOM_IDLE_TIMEOUT: %v", err)
+		}
+	}
+	go roomService.RunIdleSweeper(ctx, idleTimeout)
+
+	// Deliver room events to WebSocket clients; returns once every client
+	// has been disconnected on shutdown
+	wsDone := make(chan struct{})
+	go func() {
+		wsHandler.Run(ctx)
+		close(wsDone)
+	}()
 
 	// Initialize Gin router
 	router := gin.Default()
@@ -148,8 +240,38 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+	go func() {
+		log.Printf("Server starting on port %s", port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// Shutdown does not wait for hijacked WebSocket connections; the
+	// handler closes those itself now that ctx is done
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down server: %v", err)
+	}
+	select {
+	case <-wsDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Timed out disconnecting WebSocket clients")
+	}
+
+	if err := kafkaClient.Close(); err != nil {
+		log.Printf("Failed to close Kafka client: %v", err)
+	}
+	if err := redisClient.Close(); err != nil {
+		log.Printf("Failed to close Redis client: %v", err)
 	}
 }
@@ -23,8 +23,18 @@ export default function Home({ token, onJoin }) {
     const res = await fetch(`/api/v1/rooms/code/${code}`, {
       headers: { Authorization: 'Bearer ' + token },
     });
-    if (res.ok) {
-      const room = await res.json();
+    if (!res.ok) return;
+    const room = await res.json();
+    const passcode = room.private ? prompt('Room passcode') || '' : '';
+    const joinRes = await fetch(`/api/v1/rooms/${room.id}/join`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        'Authorization': 'Bearer ' + token,
+      },
+      body: JSON.stringify({ passcode }),
+    });
+    if (joinRes.ok) {
       onJoin(room);
     }
   };
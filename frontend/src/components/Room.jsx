@@ -2,15 +2,26 @@ import React, { useState, useEffect } from 'react';
 
 export default function Room({ token, room }) {
   const [queue, setQueue] = useState([]);
+  const [playback, setPlayback] = useState(null);
+  const [query, setQuery] = useState('');
+  const [results, setResults] = useState([]);
+  const [credits, setCredits] = useState(null);
 
   useEffect(() => {
     loadQueue();
+    loadPlayback();
+    loadCredits();
     const protocol = window.location.protocol === 'https:' ? 'wss' : 'ws';
     const ws = new WebSocket(
-      `${protocol}://${window.location.host}/api/v1/ws/${room.id}?token=${token}`
+      `${protocol}://${window.location.host}/api/v1/ws/${room.id}?token=${token}`,
+      ['muzer.v1']
     );
-    ws.onmessage = () => {
-      loadQueue();
+    ws.onmessage = (e) => {
+      const msg = JSON.parse(e.data);
+      if (msg.type === 'event') {
+        loadQueue();
+        loadPlayback();
+      }
     };
     return () => {
       ws.close();
@@ -27,32 +38,114 @@ export default function Room({ token, room }) {
     }
   };
 
-  const addSong = async () => {
-    const trackID = prompt('Track ID:');
-    const trackName = prompt('Track name:');
-    const artist = prompt('Artist:');
-    if (!trackID || !trackName || !artist) return;
+  const loadCredits = async () => {
+    const res = await fetch(`/api/v1/rooms/${room.id}/credits`, {
+      headers: { Authorization: 'Bearer ' + token },
+    });
+    if (res.ok) {
+      setCredits(await res.json());
+    }
+  };
+
+  const loadPlayback = async () => {
+    const res = await fetch(`/api/v1/rooms/${room.id}/playback`, {
+      headers: { Authorization: 'Bearer ' + token },
+    });
+    if (res.ok) {
+      const data = await res.json();
+      setPlayback(data.playback);
+    }
+  };
+
+  const controlPlayback = async (action) => {
+    await fetch(`/api/v1/rooms/${room.id}/playback/${action}`, {
+      method: 'POST',
+      headers: { Authorization: 'Bearer ' + token },
+    });
+    loadPlayback();
+    loadQueue();
+  };
+
+  const voteSkip = async () => {
+    await fetch(`/api/v1/rooms/${room.id}/playback/skip-votes`, {
+      method: 'POST',
+      headers: { Authorization: 'Bearer ' + token },
+    });
+    loadPlayback();
+  };
+
+  const search = async (e) => {
+    e.preventDefault();
+    if (!query.trim()) return;
+    const params = new URLSearchParams({ q: query, type: 'track', limit: '10' });
+    const res = await fetch(`/api/v1/search?${params}`, {
+      headers: { Authorization: 'Bearer ' + token },
+    });
+    if (res.ok) {
+      const data = await res.json();
+      setResults(data.tracks ? data.tracks.items : []);
+    }
+  };
+
+  const addSong = async (track) => {
     await fetch(`/api/v1/rooms/${room.id}/queue`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
         Authorization: 'Bearer ' + token,
       },
-      body: JSON.stringify({ track_id: trackID, track_name: trackName, artist }),
+      body: JSON.stringify({ track_id: track.id }),
     });
+    setResults([]);
+    setQuery('');
     loadQueue();
   };
 
-  const vote = async (trackID, value) => {
+  const vote = async (itemID, value, weight = 1) => {
     await fetch(`/api/v1/rooms/${room.id}/vote`, {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
         Authorization: 'Bearer ' + token,
       },
-      body: JSON.stringify({ track_id: trackID, vote: value }),
+      body: JSON.stringify({ queue_item_id: itemID, vote: value, weight }),
+    });
+    loadQueue();
+    loadCredits();
+  };
+
+  const retractVote = async (itemID) => {
+    await fetch(`/api/v1/rooms/${room.id}/votes/${itemID}`, {
+      method: 'DELETE',
+      headers: { Authorization: 'Bearer ' + token },
     });
     loadQueue();
+    loadCredits();
+  };
+
+  const toggleVote = (item, value) => {
+    if (item.my_vote === value) {
+      retractVote(item.id);
+    } else {
+      vote(item.id, value);
+    }
+  };
+
+  const exportPlaylist = async (source) => {
+    const res = await fetch(`/api/v1/rooms/${room.id}/export`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        Authorization: 'Bearer ' + token,
+      },
+      body: JSON.stringify({ source }),
+    });
+    const data = await res.json();
+    if (res.ok) {
+      alert(`Saved ${data.tracks} songs to "${data.name}"`);
+    } else {
+      alert(data.error);
+    }
   };
 
   const nextSong = async () => {
@@ -73,10 +166,35 @@ export default function Room({ token, room }) {
         <h2 className="text-xl font-bold">
           Room: {room.name} (Code: {room.code})
         </h2>
-        <button className="btn" onClick={nextSong}>
-          Next Song
-        </button>
+        <div className="space-x-2">
+          <button className="btn" onClick={() => controlPlayback('play')}>
+            Play
+          </button>
+          <button className="btn" onClick={() => controlPlayback('pause')}>
+            Pause
+          </button>
+          <button className="btn" onClick={() => controlPlayback('skip')}>
+            Skip
+          </button>
+          <button className="btn" onClick={voteSkip}>
+            Vote to skip
+          </button>
+          <button className="btn" onClick={() => exportPlaylist('history')}>
+            Save playlist
+          </button>
+        </div>
       </div>
+      {playback && playback.track_name && (
+        <div className="alert">
+          {playback.status === 'paused' ? 'Paused' : 'Now playing'}: {playback.track_name} by{' '}
+          {playback.artist}
+        </div>
+      )}
+      {credits && credits.mode === 'credits' && (
+        <div className="text-sm">
+          Vote credits: {credits.balance} / {credits.per_hour}
+        </div>
+      )}
       <ul className="menu bg-base-200 rounded-box w-full">
         {queue.map((item) => (
           <li key={item.id} className="flex justify-between items-center">
@@ -84,20 +202,50 @@ export default function Room({ token, room }) {
               {item.track_name} by {item.artist}
             </span>
             <div className="flex items-center space-x-2">
-              <button className="btn btn-xs" onClick={() => vote(item.track_id, 1)}>
-                +
+              <button
+                className={'btn btn-xs' + (item.my_vote === 1 ? ' btn-success' : '')}
+                onClick={() => toggleVote(item, 1)}
+              >
+                + {item.up_votes}
               </button>
-              <span>{item.votes}</span>
-              <button className="btn btn-xs" onClick={() => vote(item.track_id, -1)}>
-                -
+              {credits && credits.mode === 'credits' && (
+                <button className="btn btn-xs" onClick={() => vote(item.id, 1, 2)}>
+                  ++
+                </button>
+              )}
+              <button
+                className={'btn btn-xs' + (item.my_vote === -1 ? ' btn-error' : '')}
+                onClick={() => toggleVote(item, -1)}
+              >
+                - {item.down_votes}
               </button>
             </div>
           </li>
         ))}
       </ul>
-      <button className="btn btn-primary" onClick={addSong}>
-        Add Song
-      </button>
+      <form className="flex space-x-2" onSubmit={search}>
+        <input
+          className="input input-bordered flex-1"
+          placeholder="Search for a song"
+          value={query}
+          onChange={(e) => setQuery(e.target.value)}
+        />
+        <button className="btn btn-primary" type="submit">
+          Search
+        </button>
+      </form>
+      <ul className="menu bg-base-200 rounded-box w-full">
+        {results.map((track) => (
+          <li key={track.id} className="flex justify-between items-center">
+            <span>
+              {track.name} by {track.artists.map((a) => a.name).join(', ')}
+            </span>
+            <button className="btn btn-xs" onClick={() => addSong(track)}>
+              Add
+            </button>
+          </li>
+        ))}
+      </ul>
     </div>
   );
 }
\ No newline at end of file
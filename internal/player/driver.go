@@ -0,0 +1,324 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/music-queue-system/internal/room"
+	"github.com/music-queue-system/internal/spotify"
+	"github.com/music-queue-system/pkg/models"
+	"github.com/music-queue-system/pkg/redis"
+)
+
+const (
+	driverLockPrefix   = "player:driver:"
+	driverDevicePrefix = "player:device:"
+	// driverPlayingPrefix remembers the ResumedAt of the room state the
+	// device was last seen or made to play, so a paused device can be told
+	// apart from one that has not caught up with a resume yet.
+	driverPlayingPrefix = "player:playing:"
+	// The lock outlives a few poll intervals so a busy instance does not
+	// lose its rooms, while a crashed one hands them over quickly.
+	driverLockTTL      = 15 * time.Second
+	driverScanInterval = 5 * time.Second
+	driverPollInterval = 5 * time.Second
+	driverMinBackoff   = 2 * time.Second
+	driverMaxBackoff   = 2 * time.Minute
+	// driverDeviceTTL matches the lifetime of the room's playback state, so
+	// a room's last device is forgotten along with it.
+	driverDeviceTTL = 24 * time.Hour
+	// endTolerance is how close to its end a track may stop on the device
+	// and still count as finished rather than paused.
+	endTolerance = 3 * time.Second
+)
+
+var ErrNoDevice = errors.New("host has no available spotify device")
+
+// Driver plays each active room's current track on its host's Spotify
+// device. Every server instance runs one; a Redis lock per room makes sure
+// only one instance drives a given room, and since all state lives in
+// Redis another instance picks the room up if this one stops.
+type Driver struct {
+	rooms  *room.Service
+	player *Service
+	redis  *goredis.Client
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func NewDriver(roomService *room.Service, playerService *Service, redisClient *goredis.Client) *Driver {
+	return &Driver{
+		rooms:   roomService,
+		player:  playerService,
+		redis:   redisClient,
+		running: make(map[string]bool),
+	}
+}
+
+// Run claims active rooms until ctx is cancelled.
+func (d *Driver) Run(ctx context.Context) {
+	ticker := time.NewTicker(driverScanInterval)
+	defer ticker.Stop()
+
+	for {
+		d.claimRooms(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Driver) claimRooms(ctx context.Context) {
+	roomIDs, err := d.rooms.ActivePlaybackRooms(ctx)
+	if err != nil {
+		log.Printf("Driver: %v", err)
+		return
+	}
+
+	for _, roomID := range roomIDs {
+		d.mu.Lock()
+		running := d.running[roomID]
+		d.mu.Unlock()
+		if running {
+			continue
+		}
+
+		lock, err := redis.TryLock(ctx, d.redis, driverLockPrefix+roomID, driverLockTTL)
+		if err != nil {
+			if !errors.Is(err, redis.ErrLockHeld) {
+				log.Printf("Driver: %v", err)
+			}
+			continue
+		}
+
+		d.mu.Lock()
+		d.running[roomID] = true
+		d.mu.Unlock()
+
+		go d.driveRoom(ctx, roomID, lock)
+	}
+}
+
+// driveRoom keeps the host's device in step with the room until playback
+// stops, the lock is lost or ctx is cancelled.
+func (d *Driver) driveRoom(ctx context.Context, roomID string, lock *redis.Lock) {
+	defer func() {
+		lock.Release(context.Background())
+		d.mu.Lock()
+		delete(d.running, roomID)
+		d.mu.Unlock()
+	}()
+
+	backoff := time.Duration(0)
+	for {
+		if err := lock.Refresh(ctx); err != nil {
+			if !errors.Is(err, redis.ErrLockLost) && ctx.Err() == nil {
+				log.Printf("Driver: room %s: %v", roomID, err)
+			}
+			return
+		}
+
+		state, err := d.rooms.NowPlaying(ctx, roomID)
+		if err != nil {
+			if errors.Is(err, room.ErrRoomNotFound) {
+				return
+			}
+			log.Printf("Driver: room %s: %v", roomID, err)
+		} else if state.Status != models.PlaybackPlaying && state.Status != models.PlaybackPaused {
+			return
+		}
+
+		wait := driverPollInterval
+		if err == nil {
+			wait, err = d.sync(ctx, roomID, state)
+		}
+		if err != nil {
+			backoff = nextBackoff(backoff, err)
+			log.Printf("Driver: room %s: %v (retrying in %s)", roomID, err, backoff)
+			wait = backoff
+		} else {
+			backoff = 0
+		}
+
+		if !d.wait(ctx, roomID, lock, wait) {
+			return
+		}
+	}
+}
+
+// wait sleeps for the given duration, refreshing the lock as it goes since
+// a backoff can be longer than the lock lives. It returns false if ctx is
+// cancelled or the lock is lost.
+func (d *Driver) wait(ctx context.Context, roomID string, lock *redis.Lock, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(driverLockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			if err := lock.Refresh(ctx); err != nil {
+				if !errors.Is(err, redis.ErrLockLost) && ctx.Err() == nil {
+					log.Printf("Driver: room %s: %v", roomID, err)
+				}
+				return false
+			}
+		}
+	}
+}
+
+func nextBackoff(current time.Duration, err error) time.Duration {
+	// A free account or missing tokens will not fix themselves soon.
+	if errors.Is(err, spotify.ErrPremiumRequired) || errors.Is(err, ErrNoTokens) {
+		return driverMaxBackoff
+	}
+	if current == 0 {
+		return driverMinBackoff
+	}
+	if current*2 > driverMaxBackoff {
+		return driverMaxBackoff
+	}
+	return current * 2
+}
+
+// sync makes one pass of reconciling the host's device with the room state
+// and returns how long to wait before the next pass.
+func (d *Driver) sync(ctx context.Context, roomID string, state *models.PlaybackState) (time.Duration, error) {
+	rm, err := d.rooms.GetRoom(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+	hostID := rm.HostID.String()
+
+	token, err := d.player.AccessToken(ctx, hostID)
+	if err != nil {
+		return 0, err
+	}
+
+	device, err := d.player.spotify.GetPlaybackState(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	if device != nil && device.Device.ID != "" {
+		d.redis.Set(ctx, driverDevicePrefix+roomID, device.Device.ID, driverDeviceTTL)
+	}
+
+	now := time.Now()
+	onTrack := device != nil && device.Item != nil && device.Item.ID == state.TrackID
+
+	if state.Status == models.PlaybackPaused {
+		if onTrack && device.IsPlaying {
+			if err := d.player.spotify.Pause(ctx, token, device.Device.ID); err != nil {
+				return 0, err
+			}
+		}
+		return driverPollInterval, nil
+	}
+
+	remaining := state.EndsAt().Sub(now)
+	switch {
+	case onTrack && device.IsPlaying:
+		// Playing as expected.
+		d.markPlaying(ctx, roomID, state)
+	case onTrack && remaining > endTolerance && device.ProgressMs > 0 && d.wasPlaying(ctx, roomID, state):
+		// The device played this stretch of the room and has stopped, so
+		// the host paused on the device itself; follow them.
+		if _, err := d.rooms.PausePlayback(ctx, roomID, hostID); err != nil {
+			return 0, fmt.Errorf("failed to mirror device pause: %w", err)
+		}
+		return driverPollInterval, nil
+	case remaining <= endTolerance:
+		// The device finished the track (or moved on) slightly ahead of
+		// the room clock.
+		if _, err := d.rooms.AdvancePlayback(ctx, roomID, state.QueueItemID, false); err != nil {
+			return 0, err
+		}
+		return 0, nil
+	default:
+		// Nothing playing yet, another track, or the room was resumed
+		// while the device sat paused.
+		if err := d.playOnDevice(ctx, roomID, token, state.TrackID, state.Position(now)); err != nil {
+			return 0, err
+		}
+		d.markPlaying(ctx, roomID, state)
+	}
+
+	// Wake up just after the track should end so the next one starts
+	// without a gap.
+	if wait := remaining + 500*time.Millisecond; wait > 0 && wait < driverPollInterval {
+		return wait, nil
+	}
+	return driverPollInterval, nil
+}
+
+// markPlaying records that the device is playing the room since its last
+// start or resume.
+func (d *Driver) markPlaying(ctx context.Context, roomID string, state *models.PlaybackState) {
+	resumedAt := strconv.FormatInt(state.ResumedAt.UnixMilli(), 10)
+	d.redis.Set(ctx, driverPlayingPrefix+roomID, resumedAt, driverDeviceTTL)
+}
+
+// wasPlaying reports whether the device has played the room since its last
+// start or resume. A device paused since before then was paused by the
+// room itself and only needs to be told to play again.
+func (d *Driver) wasPlaying(ctx context.Context, roomID string, state *models.PlaybackState) bool {
+	resumedAt, err := d.redis.Get(ctx, driverPlayingPrefix+roomID).Int64()
+	return err == nil && resumedAt == state.ResumedAt.UnixMilli()
+}
+
+// playOnDevice starts trackID on the host's active device, falling back to
+// the device last seen for the room or any available one when Spotify
+// reports there is no active device.
+func (d *Driver) playOnDevice(ctx context.Context, roomID, token, trackID string, positionMs int) error {
+	opts := spotify.PlayOptions{TrackIDs: []string{trackID}, PositionMs: positionMs}
+	err := d.player.spotify.Play(ctx, token, opts)
+	if !errors.Is(err, spotify.ErrNoActiveDevice) {
+		return err
+	}
+
+	devices, err := d.player.spotify.GetDevices(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	lastDevice, _ := d.redis.Get(ctx, driverDevicePrefix+roomID).Result()
+	var chosen string
+	for _, dev := range devices {
+		if dev.IsRestricted {
+			continue
+		}
+		if dev.ID == lastDevice {
+			chosen = dev.ID
+			break
+		}
+		if chosen == "" {
+			chosen = dev.ID
+		}
+	}
+	if chosen == "" {
+		return ErrNoDevice
+	}
+
+	opts.DeviceID = chosen
+	if err := d.player.spotify.Play(ctx, token, opts); err != nil {
+		return err
+	}
+	d.redis.Set(ctx, driverDevicePrefix+roomID, chosen, driverDeviceTTL)
+	return nil
+}
@@ -0,0 +1,197 @@
+package player
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/music-queue-system/internal/spotify"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
+	player := r.Group("/player")
+	{
+		player.GET("", h.getState)
+		player.GET("/devices", h.getDevices)
+		player.PUT("/play", h.play)
+		player.PUT("/resume", h.resume)
+		player.PUT("/pause", h.pause)
+		player.PUT("/seek", h.seek)
+		player.POST("/next", h.next)
+		player.POST("/previous", h.previous)
+		player.PUT("/volume", h.setVolume)
+		player.PUT("/transfer", h.transfer)
+	}
+}
+
+// respondError maps Spotify player failures onto API errors with a stable
+// machine-readable code.
+func respondError(c *gin.Context, err error) {
+	status, code := http.StatusBadGateway, "spotify_error"
+	switch {
+	case errors.Is(err, ErrNoTokens), errors.Is(err, spotify.ErrUnauthorized):
+		status, code = http.StatusUnauthorized, "spotify_unauthorized"
+	case errors.Is(err, spotify.ErrNoActiveDevice):
+		status, code = http.StatusNotFound, "no_active_device"
+	case errors.Is(err, spotify.ErrPremiumRequired):
+		status, code = http.StatusForbidden, "premium_required"
+	case errors.Is(err, spotify.ErrRateLimited):
+		status, code = http.StatusTooManyRequests, "rate_limited"
+	}
+
+	c.JSON(status, gin.H{"error": err.Error(), "code": code})
+}
+
+func (h *Handler) getState(c *gin.Context) {
+	state, err := h.service.State(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if state == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, state)
+}
+
+func (h *Handler) getDevices(c *gin.Context) {
+	devices, err := h.service.Devices(c.Request.Context(), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+type PlayRequest struct {
+	TrackIDs   []string `json:"track_ids" binding:"required,min=1"`
+	PositionMs int      `json:"position_ms" binding:"min=0"`
+}
+
+func (h *Handler) play(c *gin.Context) {
+	var req PlayRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts := spotify.PlayOptions{
+		DeviceID:   c.Query("device_id"),
+		TrackIDs:   req.TrackIDs,
+		PositionMs: req.PositionMs,
+	}
+	if err := h.service.Play(c.Request.Context(), c.GetString("user_id"), opts); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) resume(c *gin.Context) {
+	opts := spotify.PlayOptions{DeviceID: c.Query("device_id")}
+	if err := h.service.Play(c.Request.Context(), c.GetString("user_id"), opts); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) pause(c *gin.Context) {
+	if err := h.service.Pause(c.Request.Context(), c.GetString("user_id"), c.Query("device_id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type SeekRequest struct {
+	PositionMs *int `json:"position_ms" binding:"required,min=0"`
+}
+
+func (h *Handler) seek(c *gin.Context) {
+	var req SeekRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.Seek(c.Request.Context(), c.GetString("user_id"), c.Query("device_id"), *req.PositionMs); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) next(c *gin.Context) {
+	if err := h.service.Next(c.Request.Context(), c.GetString("user_id"), c.Query("device_id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) previous(c *gin.Context) {
+	if err := h.service.Previous(c.Request.Context(), c.GetString("user_id"), c.Query("device_id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type VolumeRequest struct {
+	VolumePercent *int `json:"volume_percent" binding:"required,min=0,max=100"`
+}
+
+func (h *Handler) setVolume(c *gin.Context) {
+	var req VolumeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.SetVolume(c.Request.Context(), c.GetString("user_id"), c.Query("device_id"), *req.VolumePercent); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type TransferRequest struct {
+	DeviceID string `json:"device_id" binding:"required"`
+	Play     bool   `json:"play"`
+}
+
+func (h *Handler) transfer(c *gin.Context) {
+	var req TransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.Transfer(c.Request.Context(), c.GetString("user_id"), req.DeviceID, req.Play); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
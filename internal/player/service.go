@@ -0,0 +1,126 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/music-queue-system/internal/spotify"
+	"github.com/music-queue-system/pkg/redis"
+)
+
+// tokenRefreshMargin is how long before expiry a stored access token is
+// refreshed instead of being handed to Spotify.
+const tokenRefreshMargin = time.Minute
+
+var ErrNoTokens = errors.New("no spotify tokens stored for user")
+
+type Service struct {
+	spotify *spotify.Client
+	tokens  *redis.TokenStore
+}
+
+func NewService(spotifyClient *spotify.Client, tokenStore *redis.TokenStore) *Service {
+	return &Service{
+		spotify: spotifyClient,
+		tokens:  tokenStore,
+	}
+}
+
+// AccessToken returns a usable Spotify access token for the user, refreshing
+// and re-storing it when it is about to expire.
+func (s *Service) AccessToken(ctx context.Context, userID string) (string, error) {
+	tokenInfo, err := s.tokens.GetTokens(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrNoTokens, err)
+	}
+
+	if time.Now().Add(tokenRefreshMargin).Before(tokenInfo.ExpiresAt) {
+		return tokenInfo.AccessToken, nil
+	}
+
+	newToken, err := s.spotify.RefreshToken(ctx, tokenInfo.RefreshToken)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh token: %w", err)
+	}
+
+	expiresAt := time.Now().Add(time.Duration(newToken.ExpiresIn) * time.Second).UTC()
+	if err := s.tokens.RefreshToken(ctx, userID, newToken.AccessToken, expiresAt); err != nil {
+		return "", fmt.Errorf("failed to store refreshed token: %w", err)
+	}
+
+	return newToken.AccessToken, nil
+}
+
+func (s *Service) State(ctx context.Context, userID string) (*spotify.PlaybackState, error) {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.spotify.GetPlaybackState(ctx, token)
+}
+
+func (s *Service) Devices(ctx context.Context, userID string) ([]spotify.Device, error) {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.spotify.GetDevices(ctx, token)
+}
+
+func (s *Service) Play(ctx context.Context, userID string, opts spotify.PlayOptions) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.Play(ctx, token, opts)
+}
+
+func (s *Service) Pause(ctx context.Context, userID, deviceID string) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.Pause(ctx, token, deviceID)
+}
+
+func (s *Service) Seek(ctx context.Context, userID, deviceID string, positionMs int) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.Seek(ctx, token, deviceID, positionMs)
+}
+
+func (s *Service) Next(ctx context.Context, userID, deviceID string) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.SkipToNext(ctx, token, deviceID)
+}
+
+func (s *Service) Previous(ctx context.Context, userID, deviceID string) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.SkipToPrevious(ctx, token, deviceID)
+}
+
+func (s *Service) SetVolume(ctx context.Context, userID, deviceID string, volumePercent int) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.SetVolume(ctx, token, deviceID, volumePercent)
+}
+
+func (s *Service) Transfer(ctx context.Context, userID, deviceID string, play bool) error {
+	token, err := s.AccessToken(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return s.spotify.TransferPlayback(ctx, token, deviceID, play)
+}
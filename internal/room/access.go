@@ -0,0 +1,170 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/music-queue-system/pkg/jwt"
+	"github.com/music-queue-system/pkg/models"
+)
+
+const (
+	minPasscodeLength = 4
+	defaultInviteTTL  = 24 * time.Hour
+	maxInviteTTL      = 30 * 24 * time.Hour
+)
+
+func hashPasscode(passcode string) (string, error) {
+	if len(passcode) < minPasscodeLength {
+		return "", fmt.Errorf("%w: passcode must be at least %d characters", ErrInvalidSetting, minPasscodeLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash passcode: %w", err)
+	}
+	return string(hash), nil
+}
+
+// checkPasscode lets the user into a private room. Private rooms without a
+// passcode are invite-only.
+func (s *Service) checkPasscode(roomID, passcode string) error {
+	// The cached room has no passcode hash, so read it from the database
+	room, err := s.db.GetRoomByID(roomID)
+	if err != nil {
+		return fmt.Errorf("failed to get room: %w", err)
+	}
+	if !room.Private {
+		return nil
+	}
+	if room.PasscodeHash == "" {
+		return ErrInviteRequired
+	}
+	if bcrypt.CompareHashAndPassword([]byte(room.PasscodeHash), []byte(passcode)) != nil {
+		return ErrWrongPasscode
+	}
+	return nil
+}
+
+// CreateInvite issues an invite link token for the room. A zero ttl uses
+// the default lifetime and maxUses 0 allows unlimited uses.
+func (s *Service) CreateInvite(ctx context.Context, roomID, userID string, ttl time.Duration, maxUses int) (*models.RoomInvite, string, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermManageMembers); err != nil {
+		return nil, "", err
+	}
+	creator, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: invalid user ID", ErrForbidden)
+	}
+
+	if ttl == 0 {
+		ttl = defaultInviteTTL
+	}
+	if ttl < 0 || ttl > maxInviteTTL {
+		return nil, "", fmt.Errorf("%w: invites expire after at most %s", ErrInvalidInvite, maxInviteTTL)
+	}
+	if maxUses < 0 {
+		return nil, "", fmt.Errorf("%w: max uses cannot be negative", ErrInvalidInvite)
+	}
+
+	now := time.Now()
+	invite := &models.RoomInvite{
+		ID:        uuid.New(),
+		RoomID:    room.ID,
+		CreatedBy: creator,
+		ExpiresAt: now.Add(ttl),
+		MaxUses:   maxUses,
+		CreatedAt: now,
+	}
+	if err := s.db.CreateInvite(invite); err != nil {
+		return nil, "", fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	token, err := jwt.GenerateInviteToken(invite.ID.String(), room.ID.String(), invite.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return invite, token, nil
+}
+
+func (s *Service) ListInvites(ctx context.Context, roomID, userID string) ([]*models.RoomInvite, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermManageMembers); err != nil {
+		return nil, err
+	}
+
+	invites, err := s.db.ListInvites(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invites: %w", err)
+	}
+	return invites, nil
+}
+
+func (s *Service) RevokeInvite(ctx context.Context, roomID, userID, inviteID string) error {
+	if err := s.Authorize(ctx, roomID, userID, PermManageMembers); err != nil {
+		return err
+	}
+
+	revoked, err := s.db.RevokeInvite(roomID, inviteID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to revoke invite: %w", err)
+	}
+	if !revoked {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// RedeemInvite joins the user to the invite's room, bypassing the passcode.
+// Members who are already in the room do not use up the invite.
+func (s *Service) RedeemInvite(ctx context.Context, userID, token string) (*models.Room, *models.RoomMember, error) {
+	claims, err := jwt.ValidateInviteToken(token)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidInvite, err)
+	}
+	user, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: invalid user ID", ErrForbidden)
+	}
+
+	room, err := s.GetRoom(ctx, claims.RoomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !room.Active {
+		return nil, nil, ErrRoomClosed
+	}
+
+	existing, err := s.loadMember(ctx, claims.RoomID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if effectiveRole(room, existing) == models.RoleBanned {
+		return nil, nil, ErrBanned
+	}
+	if existing != nil && existing.IsMember() {
+		return room, existing, nil
+	}
+
+	ok, err := s.db.RedeemInvite(claims.InviteID, time.Now())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to redeem invite: %w", err)
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: invite is expired, revoked or used up", ErrInvalidInvite)
+	}
+
+	member, err := s.addMember(ctx, room, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return room, member, nil
+}
@@ -0,0 +1,175 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+const (
+	creditsKeyPrefix = "votecredits:"
+
+	// maxVoteWeight caps super-votes; a vote of weight w costs w*w credits.
+	maxVoteWeight = 3
+
+	maxVoteCreditsPerHour = 1000
+)
+
+// spendCreditsScript refills a member's balance for the time since it was
+// last touched, capped at one hour's worth, then takes ARGV[3] credits
+// from it. Negative amounts are refunds. Unless ARGV[4] is "1" a charge the
+// balance cannot cover is refused and nothing changes; forced charges stop
+// at zero. A missing ledger is a full one, so the key only has to live as
+// long as a refill from empty takes.
+var spendCreditsScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local perHour = tonumber(ARGV[2])
+local amount = tonumber(ARGV[3])
+local state = redis.call("hmget", KEYS[1], "balance", "updated")
+local balance = tonumber(state[1])
+local updated = tonumber(state[2])
+if balance == nil or updated == nil then
+	balance = perHour
+	updated = now
+end
+balance = math.min(perHour, balance + (now - updated) * perHour / 3600000)
+if amount > balance and ARGV[4] ~= "1" then
+	return {0, tostring(balance)}
+end
+balance = math.max(0, math.min(perHour, balance - amount))
+redis.call("hset", KEYS[1], "balance", tostring(balance), "updated", now)
+redis.call("pexpire", KEYS[1], 3600000)
+return {1, tostring(balance)}`)
+
+// CreditBalance is a member's vote budget in a credit room.
+type CreditBalance struct {
+	Mode    string `json:"mode"`
+	Balance int    `json:"balance"`
+	PerHour int    `json:"per_hour"`
+	// NextCreditAt is when the next whole credit comes in, nil while the
+	// balance is full.
+	NextCreditAt *time.Time `json:"next_credit_at,omitempty"`
+}
+
+// Credits returns the user's vote credits in the room. Rooms with unlimited
+// voting report only their mode.
+func (s *Service) Credits(ctx context.Context, roomID, userID string) (*CreditBalance, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+	if room.VoteMode != models.VoteModeCredits {
+		return &CreditBalance{Mode: models.VoteModeUnlimited}, nil
+	}
+
+	_, balance, err := s.spendCredits(ctx, room, userID, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	return creditBalance(room, balance), nil
+}
+
+// voteCost is what a vote takes from the voter's credits: nothing in
+// unlimited rooms or for down-votes, and the square of its weight
+// otherwise.
+func voteCost(room *models.Room, vote *models.Vote) int {
+	if vote == nil || room.VoteMode != models.VoteModeCredits || vote.Value < 0 {
+		return 0
+	}
+	return vote.Weight * vote.Weight
+}
+
+// validateVoteWeight checks a vote's weight against the room's mode. Only
+// credit rooms have super-votes, and only for up-votes.
+func validateVoteWeight(room *models.Room, value, weight int) error {
+	if weight == 1 {
+		return nil
+	}
+	if room.VoteMode != models.VoteModeCredits || value < 0 {
+		return fmt.Errorf("%w: super-votes are only for up-votes in credit rooms", ErrInvalidVote)
+	}
+	if weight < 1 || weight > maxVoteWeight {
+		return fmt.Errorf("%w: weight must be between 1 and %d", ErrInvalidVote, maxVoteWeight)
+	}
+	return nil
+}
+
+// chargeCredits takes amount credits from the user, or refunds them if
+// amount is negative, returning ErrNotEnoughCredits if the balance is too
+// low. Forced charges always succeed.
+func (s *Service) chargeCredits(ctx context.Context, room *models.Room, userID string, amount int, force bool) error {
+	if amount == 0 || room.VoteMode != models.VoteModeCredits {
+		return nil
+	}
+
+	ok, _, err := s.spendCredits(ctx, room, userID, amount, force)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNotEnoughCredits
+	}
+	return nil
+}
+
+func (s *Service) spendCredits(ctx context.Context, room *models.Room, userID string, amount int, force bool) (bool, float64, error) {
+	forced := "0"
+	if force {
+		forced = "1"
+	}
+
+	key := creditsKeyPrefix + room.ID.String() + ":" + userID
+	res, err := spendCreditsScript.Run(ctx, s.redis, []string{key},
+		time.Now().UnixMilli(), creditsPerHour(room), amount, forced).Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to update vote credits: %w", err)
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("failed to update vote credits: unexpected reply %v", res)
+	}
+
+	ok, _ := res[0].(int64)
+	text, _ := res[1].(string)
+	balance, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to parse vote credits: %w", err)
+	}
+	return ok == 1, balance, nil
+}
+
+func creditBalance(room *models.Room, balance float64) *CreditBalance {
+	perHour := creditsPerHour(room)
+	result := &CreditBalance{
+		Mode:    models.VoteModeCredits,
+		Balance: int(balance),
+		PerHour: perHour,
+	}
+	if result.Balance < perHour {
+		missing := float64(result.Balance+1) - balance
+		next := time.Now().Add(time.Duration(missing / float64(perHour) * float64(time.Hour)))
+		result.NextCreditAt = &next
+	}
+	return result
+}
+
+func creditsPerHour(room *models.Room) int {
+	if room.VoteCreditsPerHour < 1 {
+		return 10
+	}
+	return room.VoteCreditsPerHour
+}
+
+func validateVoteCredits(perHour int) error {
+	if perHour < 1 || perHour > maxVoteCreditsPerHour {
+		return fmt.Errorf("%w: vote credits per hour must be between 1 and %d", ErrInvalidSetting, maxVoteCreditsPerHour)
+	}
+	return nil
+}
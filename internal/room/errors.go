@@ -0,0 +1,133 @@
+package room
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrRoomNotFound   = errors.New("room not found")
+	ErrNotHost        = errors.New("only the host can do that")
+	ErrQueueEmpty     = errors.New("no songs in queue")
+	ErrNothingPlaying = errors.New("nothing is playing")
+	ErrAlreadyPlaying = errors.New("playback is already running")
+	ErrNotPaused      = errors.New("playback is not paused")
+	ErrTrackLookup    = errors.New("failed to look up track")
+	ErrUnknownTrack   = errors.New("unknown track")
+	ErrPlaybackBusy   = errors.New("playback is being updated, try again")
+	ErrNoSkipVote     = errors.New("you have not voted to skip this song")
+
+	ErrQueueItemNotFound = errors.New("queue item not found")
+	ErrQueueItemPlaying  = errors.New("song is playing now, skip it instead")
+	ErrInvalidPosition   = errors.New("invalid queue position")
+	ErrInvalidVote       = errors.New("vote must be 1 or -1")
+	ErrNoVote            = errors.New("you have not voted on this song")
+	ErrNotEnoughCredits  = errors.New("not enough vote credits")
+	ErrVotersHidden      = errors.New("votes are anonymous in this room")
+	ErrInvalidSetting    = errors.New("invalid room setting")
+
+	ErrForbidden   = errors.New("not allowed in this room")
+	ErrBanned      = errors.New("you are banned from this room")
+	ErrInvalidRole = errors.New("invalid role")
+
+	ErrNotMember       = errors.New("join the room first")
+	ErrHostCannotLeave = errors.New("the host cannot leave their own room")
+
+	ErrWrongPasscode  = errors.New("wrong passcode")
+	ErrInviteRequired = errors.New("this room is invite-only")
+	ErrInvalidInvite  = errors.New("invalid invite")
+	ErrInviteNotFound = errors.New("invite not found")
+
+	ErrInvalidExport   = errors.New("invalid playlist export")
+	ErrNothingToExport = errors.New("no songs to export")
+	ErrSpotifyReauth   = errors.New("log in with Spotify again to allow creating playlists")
+	ErrPlaylistExport  = errors.New("failed to create Spotify playlist")
+
+	ErrRoomClosed   = errors.New("room is closed")
+	ErrRoomArchived = errors.New("room is archived")
+	ErrRoomOpen     = errors.New("room is already open")
+)
+
+type apiError struct {
+	status int
+	code   string
+}
+
+// apiErrors maps service errors onto HTTP responses. Anything not listed
+// is reported as an internal error.
+var apiErrors = []struct {
+	err error
+	apiError
+}{
+	{ErrRoomNotFound, apiError{http.StatusNotFound, "room_not_found"}},
+	{ErrNotHost, apiError{http.StatusForbidden, "not_host"}},
+	{ErrQueueEmpty, apiError{http.StatusNotFound, "queue_empty"}},
+	{ErrNothingPlaying, apiError{http.StatusConflict, "nothing_playing"}},
+	{ErrAlreadyPlaying, apiError{http.StatusConflict, "already_playing"}},
+	{ErrNotPaused, apiError{http.StatusConflict, "not_paused"}},
+	{ErrTrackLookup, apiError{http.StatusBadGateway, "track_lookup_failed"}},
+	{ErrInvalidExport, apiError{http.StatusBadRequest, "invalid_export"}},
+	{ErrNothingToExport, apiError{http.StatusUnprocessableEntity, "nothing_to_export"}},
+	{ErrSpotifyReauth, apiError{http.StatusForbidden, "spotify_reauth_required"}},
+	{ErrPlaylistExport, apiError{http.StatusBadGateway, "playlist_export_failed"}},
+	{ErrUnknownTrack, apiError{http.StatusBadRequest, "unknown_track"}},
+	{ErrPlaybackBusy, apiError{http.StatusConflict, "playback_busy"}},
+	{ErrNoSkipVote, apiError{http.StatusNotFound, "no_skip_vote"}},
+	{ErrQueueItemNotFound, apiError{http.StatusNotFound, "queue_item_not_found"}},
+	{ErrQueueItemPlaying, apiError{http.StatusConflict, "queue_item_playing"}},
+	{ErrInvalidPosition, apiError{http.StatusBadRequest, "invalid_position"}},
+	{ErrInvalidVote, apiError{http.StatusBadRequest, "invalid_vote"}},
+	{ErrNoVote, apiError{http.StatusNotFound, "no_vote"}},
+	{ErrNotEnoughCredits, apiError{http.StatusTooManyRequests, "not_enough_credits"}},
+	{ErrVotersHidden, apiError{http.StatusForbidden, "voters_hidden"}},
+	{ErrInvalidSetting, apiError{http.StatusBadRequest, "invalid_setting"}},
+	{ErrForbidden, apiError{http.StatusForbidden, "forbidden"}},
+	{ErrBanned, apiError{http.StatusForbidden, "banned"}},
+	{ErrInvalidRole, apiError{http.StatusBadRequest, "invalid_role"}},
+	{ErrNotMember, apiError{http.StatusForbidden, "not_member"}},
+	{ErrHostCannotLeave, apiError{http.StatusConflict, "host_cannot_leave"}},
+	{ErrWrongPasscode, apiError{http.StatusForbidden, "wrong_passcode"}},
+	{ErrInviteRequired, apiError{http.StatusForbidden, "invite_required"}},
+	{ErrInvalidInvite, apiError{http.StatusBadRequest, "invalid_invite"}},
+	{ErrInviteNotFound, apiError{http.StatusNotFound, "invite_not_found"}},
+	{ErrRoomClosed, apiError{http.StatusConflict, "room_closed"}},
+	{ErrRoomArchived, apiError{http.StatusConflict, "room_archived"}},
+	{ErrRoomOpen, apiError{http.StatusConflict, "room_open"}},
+}
+
+// ErrorCode returns the machine-readable code and message reported for a
+// service error, for callers that answer without HTTP. Unlisted errors are
+// reported as "internal_error" without their details.
+func ErrorCode(err error) (code, message string) {
+	var violation *RuleViolation
+	if errors.As(err, &violation) {
+		return violation.Rule, violation.Message
+	}
+
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			return e.code, err.Error()
+		}
+	}
+
+	return "internal_error", "internal error"
+}
+
+func respondError(c *gin.Context, err error) {
+	var violation *RuleViolation
+	if errors.As(err, &violation) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": violation.Message, "code": violation.Rule})
+		return
+	}
+
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			c.JSON(e.status, gin.H{"error": err.Error(), "code": e.code})
+			return
+		}
+	}
+
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
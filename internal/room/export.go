@@ -0,0 +1,142 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/music-queue-system/internal/spotify"
+)
+
+// Sources a playlist can be exported from.
+const (
+	ExportHistory = "history"
+	ExportQueue   = "queue"
+)
+
+// ExportOptions chooses what goes into an exported playlist.
+type ExportOptions struct {
+	// Source is ExportHistory, the songs played this session, or
+	// ExportQueue, the upcoming songs in play order.
+	Source string
+	// Name defaults to the room name and date.
+	Name   string
+	Public bool
+	// IncludeSkipped keeps skipped songs in a history export.
+	IncludeSkipped bool
+}
+
+// PlaylistExport is a playlist created from a room.
+type PlaylistExport struct {
+	PlaylistID string `json:"playlist_id"`
+	Name       string `json:"name"`
+	URL        string `json:"url,omitempty"`
+	Tracks     int    `json:"tracks"`
+}
+
+// ExportPlaylist creates a Spotify playlist on the account accessToken
+// belongs to, holding the room's session history or its queue. Each track
+// is included once.
+func (s *Service) ExportPlaylist(ctx context.Context, roomID, userID, accessToken string, opts ExportOptions) (*PlaylistExport, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	var trackIDs []string
+	var verb string
+	date := time.Now()
+	switch opts.Source {
+	case ExportHistory, "":
+		since := room.CreatedAt
+		if room.OpenedAt != nil {
+			since = *room.OpenedAt
+		}
+		date = since
+		verb = "Played"
+
+		plays, err := s.db.ListPlaysSince(roomID, since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get play history: %w", err)
+		}
+		for _, play := range plays {
+			if opts.IncludeSkipped || !play.Skipped {
+				trackIDs = append(trackIDs, play.TrackID)
+			}
+		}
+	case ExportQueue:
+		verb = "Queued"
+
+		queue, err := s.GetQueue(ctx, roomID)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range queue {
+			trackIDs = append(trackIDs, item.TrackID)
+		}
+	default:
+		return nil, fmt.Errorf("%w: source must be %s or %s", ErrInvalidExport, ExportHistory, ExportQueue)
+	}
+
+	trackIDs = uniqueTracks(trackIDs)
+	if len(trackIDs) == 0 {
+		return nil, ErrNothingToExport
+	}
+
+	day := date.Format("January 2, 2006")
+	name := opts.Name
+	if name == "" {
+		name = fmt.Sprintf("%s (%s)", room.Name, day)
+	}
+
+	user, err := s.spotify.GetUser(ctx, accessToken)
+	if err != nil {
+		return nil, spotifyExportError(err)
+	}
+	playlist, err := s.spotify.CreatePlaylist(ctx, accessToken, user.ID, spotify.PlaylistOptions{
+		Name:        name,
+		Description: fmt.Sprintf("%s in %s on %s.", verb, room.Name, day),
+		Public:      opts.Public,
+	})
+	if err != nil {
+		return nil, spotifyExportError(err)
+	}
+	if _, err := s.spotify.AddPlaylistItems(ctx, accessToken, playlist.ID, trackIDs); err != nil {
+		return nil, spotifyExportError(err)
+	}
+
+	return &PlaylistExport{
+		PlaylistID: playlist.ID,
+		Name:       playlist.Name,
+		URL:        playlist.ExternalURLs["spotify"],
+		Tracks:     len(trackIDs),
+	}, nil
+}
+
+// spotifyExportError tells users whose login predates the playlist scopes
+// to log in again.
+func spotifyExportError(err error) error {
+	var apiErr *spotify.APIError
+	if errors.Is(err, spotify.ErrUnauthorized) ||
+		(errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("%w: %v", ErrSpotifyReauth, err)
+	}
+	return fmt.Errorf("%w: %v", ErrPlaylistExport, err)
+}
+
+func uniqueTracks(trackIDs []string) []string {
+	seen := make(map[string]bool, len(trackIDs))
+	unique := trackIDs[:0]
+	for _, id := range trackIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -1,10 +1,13 @@
 package room
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 	"github.com/music-queue-system/pkg/models"
 )
 
@@ -20,17 +23,61 @@ func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
 	rooms := r.Group("/rooms")
 	{
 		rooms.POST("/", h.createRoom)
+		rooms.POST("/invites/redeem", h.redeemInvite)
 		rooms.GET("/code/:code", h.getRoomByCode)
 		rooms.GET("/:id", h.getRoom)
+		rooms.PATCH("/:id/settings", h.updateSettings)
+		rooms.POST("/:id/close", h.closeRoom)
+		rooms.POST("/:id/archive", h.archiveRoom)
+		rooms.POST("/:id/reopen", h.reopenRoom)
 		rooms.POST("/:id/queue", h.addToQueue)
 		rooms.GET("/:id/queue", h.getQueue)
+		rooms.DELETE("/:id/queue/:itemId", h.removeFromQueue)
+		rooms.PUT("/:id/queue/:itemId/position", h.moveQueueItem)
+		rooms.DELETE("/:id/queue/:itemId/position", h.unpinQueueItem)
+		rooms.POST("/:id/queue/:itemId/play-next", h.playNext)
+		rooms.GET("/:id/queue/:itemId/votes", h.listVoters)
 		rooms.POST("/:id/vote", h.vote)
+		rooms.GET("/:id/votes/me", h.myVotes)
+		rooms.GET("/:id/credits", h.getCredits)
+		rooms.DELETE("/:id/votes/:itemId", h.retractVote)
+		rooms.POST("/:id/votes/reconcile", h.reconcileVotes)
+		rooms.POST("/:id/join", h.join)
+		rooms.POST("/:id/leave", h.leave)
+		rooms.GET("/:id/members", h.listMembers)
+		rooms.PUT("/:id/members/:userId/role", h.setMemberRole)
+		rooms.POST("/:id/invites", h.createInvite)
+		rooms.GET("/:id/invites", h.listInvites)
+		rooms.DELETE("/:id/invites/:inviteId", h.revokeInvite)
 		rooms.GET("/:id/next", h.getNextSong)
+		rooms.GET("/:id/playback", h.getPlayback)
+		rooms.GET("/:id/history", h.getHistory)
+		rooms.GET("/:id/recap", h.getRecap)
+		rooms.POST("/:id/export", h.exportPlaylist)
+		rooms.POST("/:id/playback/play", h.startPlayback)
+		rooms.POST("/:id/playback/pause", h.pausePlayback)
+		rooms.POST("/:id/playback/resume", h.resumePlayback)
+		rooms.POST("/:id/playback/skip", h.skipTrack)
+		rooms.GET("/:id/playback/skip-votes", h.getSkipVotes)
+		rooms.POST("/:id/playback/skip-votes", h.voteSkip)
+		rooms.DELETE("/:id/playback/skip-votes", h.withdrawSkipVote)
 	}
 }
 
+// authorize checks the caller's permission in room :id, writing the error
+// response and returning false when it is missing.
+func (h *Handler) authorize(c *gin.Context, perm Permission) bool {
+	if err := h.service.Authorize(c.Request.Context(), c.Param("id"), c.GetString("user_id"), perm); err != nil {
+		respondError(c, err)
+		return false
+	}
+	return true
+}
+
 type CreateRoomRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+	Private  bool   `json:"private"`
+	Passcode string `json:"passcode"`
 }
 
 func (h *Handler) createRoom(c *gin.Context) {
@@ -41,9 +88,9 @@ func (h *Handler) createRoom(c *gin.Context) {
 	}
 
 	userID := c.GetString("user_id") // Set by auth middleware
-	room, err := h.service.CreateRoom(c.Request.Context(), userID, req.Name)
+	room, err := h.service.CreateRoom(c.Request.Context(), userID, req.Name, req.Private, req.Passcode)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -51,10 +98,9 @@ func (h *Handler) createRoom(c *gin.Context) {
 }
 
 func (h *Handler) getRoom(c *gin.Context) {
-	roomID := c.Param("id")
-	room, err := h.service.GetRoom(c.Request.Context(), roomID)
+	room, err := h.service.ViewRoom(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -65,17 +111,63 @@ func (h *Handler) getRoomByCode(c *gin.Context) {
 	code := c.Param("code")
 	room, err := h.service.GetRoomByCode(c.Request.Context(), code)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, room)
+}
+
+func (h *Handler) closeRoom(c *gin.Context) {
+	room, err := h.service.CloseRoom(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, room)
+}
+
+func (h *Handler) archiveRoom(c *gin.Context) {
+	room, err := h.service.ArchiveRoom(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, room)
 }
 
+func (h *Handler) reopenRoom(c *gin.Context) {
+	room, err := h.service.ReopenRoom(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, room)
+}
+
+func (h *Handler) updateSettings(c *gin.Context) {
+	var req Settings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	room, err := h.service.UpdateSettings(c.Request.Context(), c.Param("id"), c.GetString("user_id"), req)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, room)
+}
+
+// AddToQueueRequest names the song by its Spotify track ID; the rest of
+// its metadata is looked up server-side.
 type AddToQueueRequest struct {
-	TrackID   string `json:"track_id" binding:"required"`
-	TrackName string `json:"track_name" binding:"required"`
-	Artist    string `json:"artist" binding:"required"`
+	TrackID string `json:"track_id" binding:"required"`
 }
 
 func (h *Handler) addToQueue(c *gin.Context) {
@@ -88,36 +180,83 @@ func (h *Handler) addToQueue(c *gin.Context) {
 		return
 	}
 
-	item := &models.QueueItem{
-		RoomID:    uuid.MustParse(roomID),
-		UserID:    uuid.MustParse(userID),
-		TrackID:   req.TrackID,
-		TrackName: req.TrackName,
-		Artist:    req.Artist,
-	}
-
-	if err := h.service.AddToQueue(c.Request.Context(), roomID, item); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	item, err := h.service.AddToQueue(c.Request.Context(), roomID, userID, req.TrackID)
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, item)
 }
 
+// getQueue returns the queue with vote counts and the caller's own votes.
 func (h *Handler) getQueue(c *gin.Context) {
-	roomID := c.Param("id")
-	queue, err := h.service.GetQueue(c.Request.Context(), roomID)
+	queue, err := h.service.QueueFor(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, queue)
 }
 
+func (h *Handler) removeFromQueue(c *gin.Context) {
+	if err := h.service.RemoveFromQueue(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("itemId")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type MoveQueueItemRequest struct {
+	Position *int `json:"position" binding:"required"`
+}
+
+// moveQueueItem pins the item at a position, overriding the ranking.
+func (h *Handler) moveQueueItem(c *gin.Context) {
+	var req MoveQueueItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	queue, err := h.service.MoveQueueItem(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("itemId"), *req.Position)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, queue)
+}
+
+func (h *Handler) unpinQueueItem(c *gin.Context) {
+	queue, err := h.service.UnpinQueueItem(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("itemId"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, queue)
+}
+
+func (h *Handler) playNext(c *gin.Context) {
+	queue, err := h.service.PlayNext(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("itemId"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, queue)
+}
+
+// VoteRequest names the queue item being voted on, not the Spotify track,
+// since the same track can be queued more than once.
 type VoteRequest struct {
-	TrackID string `json:"track_id" binding:"required"`
-	Vote    int    `json:"vote" binding:"required,oneof=-1 1"`
+	QueueItemID string `json:"queue_item_id" binding:"required"`
+	Vote        int    `json:"vote" binding:"required,oneof=-1 1"`
+	// Weight makes an up-vote a super-vote in credit rooms. Defaults to 1.
+	Weight int `json:"weight"`
 }
 
 func (h *Handler) vote(c *gin.Context) {
@@ -130,19 +269,135 @@ func (h *Handler) vote(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.Vote(c.Request.Context(), roomID, req.TrackID, userID, req.Vote); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := h.service.Vote(c.Request.Context(), roomID, userID, req.QueueItemID, req.Vote, req.Weight); err != nil {
+		respondError(c, err)
 		return
 	}
 
 	c.Status(http.StatusOK)
 }
 
+func (h *Handler) retractVote(c *gin.Context) {
+	if err := h.service.RetractVote(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("itemId")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) myVotes(c *gin.Context) {
+	votes, err := h.service.MyVotes(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, votes)
+}
+
+// getHistory handles GET /rooms/:id/history?limit=&offset=
+func (h *Handler) getHistory(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a number"})
+		return
+	}
+
+	page, err := h.service.History(c.Request.Context(), c.Param("id"), c.GetString("user_id"), limit, offset)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *Handler) getRecap(c *gin.Context) {
+	recap, err := h.service.Recap(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, recap)
+}
+
+type ExportPlaylistRequest struct {
+	// Source is "history" (the default) or "queue".
+	Source         string `json:"source"`
+	Name           string `json:"name" binding:"max=100"`
+	Public         bool   `json:"public"`
+	IncludeSkipped bool   `json:"include_skipped"`
+}
+
+// exportPlaylist saves the room's songs as a playlist on the caller's
+// Spotify account.
+func (h *Handler) exportPlaylist(c *gin.Context) {
+	var req ExportPlaylistRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	export, err := h.service.ExportPlaylist(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.GetString("access_token"), ExportOptions{
+		Source:         req.Source,
+		Name:           req.Name,
+		Public:         req.Public,
+		IncludeSkipped: req.IncludeSkipped,
+	})
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, export)
+}
+
+func (h *Handler) getCredits(c *gin.Context) {
+	balance, err := h.service.Credits(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, balance)
+}
+
+func (h *Handler) listVoters(c *gin.Context) {
+	voters, err := h.service.Voters(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("itemId"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, voters)
+}
+
+func (h *Handler) reconcileVotes(c *gin.Context) {
+	fixed, err := h.service.ReconcileVotes(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": fixed})
+}
+
 func (h *Handler) getNextSong(c *gin.Context) {
 	roomID := c.Param("id")
+	if !h.authorize(c, PermView) {
+		return
+	}
+
 	song, err := h.service.GetNextSong(c.Request.Context(), roomID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
@@ -153,3 +408,228 @@ func (h *Handler) getNextSong(c *gin.Context) {
 
 	c.JSON(http.StatusOK, song)
 }
+
+func (h *Handler) getPlayback(c *gin.Context) {
+	if !h.authorize(c, PermView) {
+		return
+	}
+
+	state, err := h.service.NowPlaying(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, playbackResponse(state))
+}
+
+func (h *Handler) startPlayback(c *gin.Context) {
+	state, err := h.service.StartPlayback(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, playbackResponse(state))
+}
+
+func (h *Handler) pausePlayback(c *gin.Context) {
+	state, err := h.service.PausePlayback(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, playbackResponse(state))
+}
+
+func (h *Handler) resumePlayback(c *gin.Context) {
+	state, err := h.service.ResumePlayback(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, playbackResponse(state))
+}
+
+func (h *Handler) skipTrack(c *gin.Context) {
+	state, err := h.service.SkipTrack(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, playbackResponse(state))
+}
+
+// playbackResponse adds the live position so clients can seek without
+// doing the clock arithmetic themselves.
+func playbackResponse(state *models.PlaybackState) gin.H {
+	now := time.Now()
+	return gin.H{
+		"playback":    state,
+		"position_ms": state.Position(now),
+		"server_time": now,
+	}
+}
+
+func (h *Handler) getSkipVotes(c *gin.Context) {
+	progress, err := h.service.SkipVotes(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+func (h *Handler) voteSkip(c *gin.Context) {
+	progress, err := h.service.VoteSkip(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+func (h *Handler) withdrawSkipVote(c *gin.Context) {
+	progress, err := h.service.WithdrawSkipVote(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, progress)
+}
+
+type JoinRequest struct {
+	Passcode string `json:"passcode"`
+}
+
+func (h *Handler) join(c *gin.Context) {
+	// The body is optional; only private rooms need a passcode
+	var req JoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.service.Join(c.Request.Context(), c.Param("id"), c.GetString("user_id"), req.Passcode)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+func (h *Handler) leave(c *gin.Context) {
+	if err := h.service.Leave(c.Request.Context(), c.Param("id"), c.GetString("user_id")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// listMembers handles GET /rooms/:id/members?active=true
+func (h *Handler) listMembers(c *gin.Context) {
+	activeOnly := c.Query("active") == "true"
+	members, err := h.service.ListMembers(c.Request.Context(), c.Param("id"), c.GetString("user_id"), activeOnly)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+type SetRoleRequest struct {
+	Role models.RoomRole `json:"role" binding:"required"`
+}
+
+func (h *Handler) setMemberRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.service.SetMemberRole(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("userId"), req.Role)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+type CreateInviteRequest struct {
+	// ExpiresIn is the invite lifetime in seconds; 0 uses the default.
+	ExpiresIn int `json:"expires_in" binding:"min=0"`
+	MaxUses   int `json:"max_uses" binding:"min=0"`
+}
+
+func (h *Handler) createInvite(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ttl := time.Duration(req.ExpiresIn) * time.Second
+	invite, token, err := h.service.CreateInvite(c.Request.Context(), c.Param("id"), c.GetString("user_id"), ttl, req.MaxUses)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"invite": invite,
+		"token":  token,
+	})
+}
+
+func (h *Handler) listInvites(c *gin.Context) {
+	invites, err := h.service.ListInvites(c.Request.Context(), c.Param("id"), c.GetString("user_id"))
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+func (h *Handler) revokeInvite(c *gin.Context) {
+	if err := h.service.RevokeInvite(c.Request.Context(), c.Param("id"), c.GetString("user_id"), c.Param("inviteId")); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type RedeemInviteRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func (h *Handler) redeemInvite(c *gin.Context) {
+	var req RedeemInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	room, member, err := h.service.RedeemInvite(c.Request.Context(), c.GetString("user_id"), req.Token)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"room":   room,
+		"member": member,
+	})
+}
@@ -0,0 +1,99 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+
+	// recapSize is how many entries each recap list holds.
+	recapSize = 5
+)
+
+// HistoryPage is one page of a room's play history, newest first.
+type HistoryPage struct {
+	Items  []*models.PlayHistory `json:"items"`
+	Total  int64                 `json:"total"`
+	Limit  int                   `json:"limit"`
+	Offset int                   `json:"offset"`
+}
+
+// SessionRecap sums up what played in the room's current session.
+type SessionRecap struct {
+	Since           time.Time                 `json:"since"`
+	TracksPlayed    int                       `json:"tracks_played"`
+	ListeningMs     int64                     `json:"listening_ms"`
+	TopTracks       []*models.PlayHistory     `json:"top_tracks"`
+	TopContributors []*models.ContributorStat `json:"top_contributors"`
+	MostDownvoted   []*models.PlayHistory     `json:"most_downvoted"`
+}
+
+// History returns a page of the songs played in the room. limit defaults
+// to 20 and is capped at 100.
+func (s *Service) History(ctx context.Context, roomID, userID string, limit, offset int) (*HistoryPage, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	plays, total, err := s.db.ListPlayHistory(roomID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get play history: %w", err)
+	}
+	return &HistoryPage{Items: plays, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// Recap sums up the room's current session, which began when the room was
+// created or last reopened.
+func (s *Service) Recap(ctx context.Context, roomID, userID string) (*SessionRecap, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	recap := &SessionRecap{Since: room.CreatedAt}
+	if room.OpenedAt != nil {
+		recap.Since = *room.OpenedAt
+	}
+
+	if recap.TracksPlayed, recap.ListeningMs, err = s.db.PlayTotals(roomID, recap.Since); err != nil {
+		return nil, fmt.Errorf("failed to total plays: %w", err)
+	}
+	if recap.TopTracks, err = s.db.TopPlays(roomID, recap.Since, "score DESC", recapSize); err != nil {
+		return nil, fmt.Errorf("failed to get top tracks: %w", err)
+	}
+	if recap.TopContributors, err = s.db.TopContributors(roomID, recap.Since, recapSize); err != nil {
+		return nil, fmt.Errorf("failed to get top contributors: %w", err)
+	}
+
+	downvoted, err := s.db.TopPlays(roomID, recap.Since, "down_votes DESC", recapSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get most downvoted tracks: %w", err)
+	}
+	recap.MostDownvoted = make([]*models.PlayHistory, 0, len(downvoted))
+	for _, play := range downvoted {
+		if play.DownVotes > 0 {
+			recap.MostDownvoted = append(recap.MostDownvoted, play)
+		}
+	}
+
+	return recap, nil
+}
@@ -0,0 +1,276 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
+	redislock "github.com/music-queue-system/pkg/redis"
+)
+
+const (
+	touchKeyPrefix = "room:touch:"
+	// touchInterval throttles LastActiveAt writes to one per room per
+	// interval.
+	touchInterval = time.Minute
+
+	idleSweepInterval = 5 * time.Minute
+	idleSweepBatch    = 100
+	// idleSweepLock lets one instance sweep per interval. It is never
+	// released, so it expires just before the next round of sweeps.
+	idleSweepLock    = "room:idle-sweep"
+	idleSweepLockTTL = idleSweepInterval - 10*time.Second
+
+	// presenceKeyPrefix keys a sorted set per room of the connections on
+	// every instance, as "userID connID" scored by the unix millisecond at
+	// which the entry expires.
+	presenceKeyPrefix = "room:presence:"
+	// presenceTTL outlives a few refreshes, so the connections of an
+	// instance that died stop counting soon after.
+	presenceTTL = 3 * time.Minute
+)
+
+// Reasons carried by room_closed events.
+const (
+	CloseReasonHost = "host"
+	CloseReasonIdle = "idle"
+)
+
+// CloseRoom ends the room's session: playback stops, writes are rejected
+// and its code can be given to a new room. The host can reopen it.
+func (s *Service) CloseRoom(ctx context.Context, roomID, userID string) (*models.Room, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.HostID.String() != userID {
+		return nil, ErrNotHost
+	}
+	if !room.Active {
+		return nil, ErrRoomClosed
+	}
+
+	return s.closeRoom(ctx, roomID, userID, CloseReasonHost)
+}
+
+// ArchiveRoom closes the room for good. Archived rooms keep their history
+// but cannot be reopened.
+func (s *Service) ArchiveRoom(ctx context.Context, roomID, userID string) (*models.Room, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.HostID.String() != userID {
+		return nil, ErrNotHost
+	}
+	if room.ArchivedAt != nil {
+		return nil, ErrRoomArchived
+	}
+
+	if room.Active {
+		if _, err := s.closeRoom(ctx, roomID, userID, CloseReasonHost); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := s.db.ArchiveRoom(roomID, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to archive room: %w", err)
+	}
+
+	// Nothing reads an archived room's live state again
+	s.redis.Del(ctx, roomKeyPrefix+roomID, rotationKeyPrefix+roomID)
+
+	room, err = s.db.GetRoomByID(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+	return room, nil
+}
+
+// ReopenRoom reopens a closed room. If another room has taken its code in
+// the meantime, it gets a new one.
+func (s *Service) ReopenRoom(ctx context.Context, roomID, userID string) (*models.Room, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room.HostID.String() != userID {
+		return nil, ErrNotHost
+	}
+	if room.ArchivedAt != nil {
+		return nil, ErrRoomArchived
+	}
+	if room.Active {
+		return nil, ErrRoomOpen
+	}
+
+	code := room.Code
+	for attempt := 1; ; attempt++ {
+		reopened, err := s.db.ReopenRoom(roomID, code, time.Now())
+		if err == nil {
+			if !reopened {
+				return nil, ErrRoomOpen
+			}
+			break
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) || attempt == maxCodeAttempts {
+			return nil, fmt.Errorf("failed to reopen room: %w", err)
+		}
+		if code, err = generateRoomCode(); err != nil {
+			return nil, err
+		}
+	}
+
+	room, err = s.db.GetRoomByID(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+	s.cacheRoom(ctx, room)
+
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeRoomReopened, roomID, userID, room); err != nil {
+		log.Printf("Warning: failed to publish room reopened event: %v", err)
+	}
+	return room, nil
+}
+
+// closeRoom deactivates the room and stops its playback. It returns
+// ErrRoomClosed if the room was closed concurrently.
+func (s *Service) closeRoom(ctx context.Context, roomID, userID, reason string) (*models.Room, error) {
+	closed, err := s.db.CloseRoom(roomID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to close room: %w", err)
+	}
+	if !closed {
+		return nil, ErrRoomClosed
+	}
+
+	room, err := s.db.GetRoomByID(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+	s.cacheRoom(ctx, room)
+
+	if err := s.stopPlayback(ctx, roomID); err != nil {
+		log.Printf("Warning: failed to stop playback for closed room %s: %v", roomID, err)
+	}
+
+	payload := events.RoomClosedPayload{Reason: reason}
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeRoomClosed, roomID, userID, payload); err != nil {
+		log.Printf("Warning: failed to publish room closed event: %v", err)
+	}
+	return room, nil
+}
+
+// KeepActive records that clients are connected to the room so the idle
+// sweeper leaves it open. Connection handlers on every instance call it
+// periodically for the rooms they serve.
+func (s *Service) KeepActive(ctx context.Context, roomID string) {
+	s.touch(ctx, roomID)
+}
+
+// Connected records a client connection to the room, so it counts towards
+// the room's audience on every instance. Connection handlers call it again
+// well within presenceTTL for as long as the connection lasts.
+func (s *Service) Connected(ctx context.Context, roomID, userID, connID string) {
+	key := presenceKeyPrefix + roomID
+	expires := time.Now().Add(presenceTTL).UnixMilli()
+	pipe := s.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(expires), Member: userID + " " + connID})
+	pipe.Expire(ctx, key, presenceTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Warning: failed to record connection to room %s: %v", roomID, err)
+	}
+}
+
+// Disconnected removes a connection recorded by Connected.
+func (s *Service) Disconnected(ctx context.Context, roomID, userID, connID string) {
+	if err := s.redis.ZRem(ctx, presenceKeyPrefix+roomID, userID+" "+connID).Err(); err != nil {
+		log.Printf("Warning: failed to remove connection from room %s: %v", roomID, err)
+	}
+}
+
+// connectedUsers counts the distinct users connected to the room across
+// all instances, however many connections each has open.
+func (s *Service) connectedUsers(ctx context.Context, roomID string) (int, error) {
+	key := presenceKeyPrefix + roomID
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	pipe := s.redis.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", now)
+	entries := pipe.ZRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to count connected users: %w", err)
+	}
+
+	users := make(map[string]bool)
+	for _, entry := range entries.Val() {
+		userID, _, _ := strings.Cut(entry, " ")
+		users[userID] = true
+	}
+	return len(users), nil
+}
+
+// touch records activity in the room, writing through to MySQL at most
+// once per touchInterval.
+func (s *Service) touch(ctx context.Context, roomID string) {
+	first, err := s.redis.SetNX(ctx, touchKeyPrefix+roomID, 1, touchInterval).Result()
+	if err != nil || !first {
+		return
+	}
+	if err := s.db.TouchRoom(roomID, time.Now()); err != nil {
+		log.Printf("Warning: failed to record activity for room %s: %v", roomID, err)
+	}
+}
+
+// RunIdleSweeper closes rooms that have had no activity and no connected
+// clients for idleTimeout. Every instance may run it; a Redis lock makes
+// sure only one of them sweeps each interval.
+func (s *Service) RunIdleSweeper(ctx context.Context, idleTimeout time.Duration) {
+	ticker := time.NewTicker(idleSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.closeIdleRooms(ctx, idleTimeout)
+		}
+	}
+}
+
+func (s *Service) closeIdleRooms(ctx context.Context, idleTimeout time.Duration) {
+	if _, err := redislock.TryLock(ctx, s.redis, idleSweepLock, idleSweepLockTTL); err != nil {
+		if !errors.Is(err, redislock.ErrLockHeld) {
+			log.Printf("Failed to claim idle sweep: %v", err)
+		}
+		return
+	}
+
+	roomIDs, err := s.db.ListIdleRooms(time.Now().Add(-idleTimeout), idleSweepBatch)
+	if err != nil {
+		log.Printf("Failed to list idle rooms: %v", err)
+		return
+	}
+
+	// Rooms with connected clients are kept active through KeepActive,
+	// so they never show up here
+	for _, roomID := range roomIDs {
+		if _, err := s.closeRoom(ctx, roomID, "", CloseReasonIdle); err != nil {
+			// Another instance may have closed it first
+			if !errors.Is(err, ErrRoomClosed) {
+				log.Printf("Failed to close idle room %s: %v", roomID, err)
+			}
+			continue
+		}
+		log.Printf("Closed idle room %s", roomID)
+	}
+}
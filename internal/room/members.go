@@ -0,0 +1,198 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
+)
+
+// assignableRoles are the roles SetMemberRole accepts. Host is only ever
+// the room's creator.
+var assignableRoles = map[models.RoomRole]bool{
+	models.RoleCoHost:   true,
+	models.RoleDJ:       true,
+	models.RoleListener: true,
+	models.RoleBanned:   true,
+}
+
+// ListMembers returns everyone who joined the room with their effective
+// role, join time and contribution counts. With activeOnly, members who
+// left are omitted.
+func (s *Service) ListMembers(ctx context.Context, roomID, userID string, activeOnly bool) ([]*models.MemberSummary, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	members, err := s.db.ListRoomMembers(roomID, activeOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+
+	hostListed := false
+	for _, m := range members {
+		m.Role = effectiveRole(room, &m.RoomMember)
+		hostListed = hostListed || m.UserID == room.HostID
+	}
+
+	// Rooms created before memberships were recorded have no host row
+	if !hostListed {
+		host := &models.MemberSummary{RoomMember: models.RoomMember{
+			RoomID:    room.ID,
+			UserID:    room.HostID,
+			Role:      models.RoleHost,
+			JoinedAt:  &room.CreatedAt,
+			CreatedAt: room.CreatedAt,
+			UpdatedAt: room.CreatedAt,
+		}}
+		members = append([]*models.MemberSummary{host}, members...)
+	}
+
+	return members, nil
+}
+
+// Join makes the user a member of the room. Private rooms need the
+// passcode; invite links go through RedeemInvite instead.
+func (s *Service) Join(ctx context.Context, roomID, userID, passcode string) (*models.RoomMember, error) {
+	user, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid user ID", ErrForbidden)
+	}
+
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if !room.Active {
+		return nil, ErrRoomClosed
+	}
+
+	existing, err := s.loadMember(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if effectiveRole(room, existing) == models.RoleBanned {
+		return nil, ErrBanned
+	}
+	if existing != nil && existing.IsMember() {
+		return existing, nil
+	}
+
+	if room.Private {
+		if err := s.checkPasscode(roomID, passcode); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.addMember(ctx, room, user)
+}
+
+// addMember records the join and announces it.
+func (s *Service) addMember(ctx context.Context, room *models.Room, userID uuid.UUID) (*models.RoomMember, error) {
+	now := time.Now()
+	member := &models.RoomMember{
+		RoomID:    room.ID,
+		UserID:    userID,
+		JoinedAt:  &now,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.db.JoinRoom(member); err != nil {
+		return nil, fmt.Errorf("failed to join room: %w", err)
+	}
+	s.forgetMember(ctx, room.ID.String(), userID.String())
+	s.touch(ctx, room.ID.String())
+
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeMemberJoined, room.ID.String(), userID.String(), nil); err != nil {
+		log.Printf("Failed to publish member joined event: %v", err)
+	}
+
+	return member, nil
+}
+
+// Leave ends the user's membership. Their role, including a ban, is kept.
+func (s *Service) Leave(ctx context.Context, roomID, userID string) error {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if room.HostID.String() == userID {
+		return ErrHostCannotLeave
+	}
+
+	if err := s.db.LeaveRoom(roomID, userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to leave room: %w", err)
+	}
+	s.forgetMember(ctx, roomID, userID)
+
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeMemberLeft, roomID, userID, nil); err != nil {
+		log.Printf("Failed to publish member left event: %v", err)
+	}
+
+	return nil
+}
+
+// SetMemberRole promotes, demotes or bans a user. Co-hosts may manage DJs,
+// listeners and bans; only the host may grant or revoke co-host.
+func (s *Service) SetMemberRole(ctx context.Context, roomID, actorID, targetID string, role models.RoomRole) (*models.RoomMember, error) {
+	target, err := uuid.Parse(targetID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid user ID", ErrInvalidRole)
+	}
+	if !assignableRoles[role] {
+		return nil, fmt.Errorf("%w: %q cannot be assigned", ErrInvalidRole, role)
+	}
+
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	actorRole, err := s.authorize(ctx, room, actorID, PermManageMembers)
+	if err != nil {
+		return nil, err
+	}
+	if target == room.HostID {
+		return nil, fmt.Errorf("%w: the host's role cannot be changed", ErrInvalidRole)
+	}
+
+	previous, err := s.RoleOf(ctx, room, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if (previous == models.RoleCoHost || role == models.RoleCoHost) && actorRole != models.RoleHost {
+		return nil, ErrNotHost
+	}
+
+	now := time.Now()
+	member := &models.RoomMember{
+		RoomID:    room.ID,
+		UserID:    target,
+		Role:      role,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.db.SetRoomMemberRole(member); err != nil {
+		return nil, fmt.Errorf("failed to set member role: %w", err)
+	}
+	s.forgetMember(ctx, roomID, targetID)
+
+	payload := events.MemberRoleChangedPayload{
+		UserID:       targetID,
+		Role:         string(role),
+		PreviousRole: string(previous),
+	}
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeMemberRoleChanged, roomID, actorID, payload); err != nil {
+		log.Printf("Failed to publish member role event: %v", err)
+	}
+
+	return member, nil
+}
@@ -0,0 +1,203 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+type Permission string
+
+const (
+	PermView            Permission = "view"
+	PermVote            Permission = "vote"
+	PermAddSong         Permission = "add_song"
+	PermSkip            Permission = "skip"
+	PermReorder         Permission = "reorder"
+	PermRemoveSong      Permission = "remove_song"
+	PermControlPlayback Permission = "control_playback"
+	PermManageMembers   Permission = "manage_members"
+	PermManageSettings  Permission = "manage_settings"
+)
+
+var rolePermissions = map[models.RoomRole][]Permission{
+	models.RoleHost: {
+		PermView, PermVote, PermAddSong, PermSkip, PermReorder, PermRemoveSong,
+		PermControlPlayback, PermManageMembers, PermManageSettings,
+	},
+	models.RoleCoHost: {
+		PermView, PermVote, PermAddSong, PermSkip, PermReorder, PermRemoveSong,
+		PermControlPlayback, PermManageMembers,
+	},
+	models.RoleDJ:       {PermView, PermVote, PermAddSong},
+	models.RoleListener: {PermView, PermVote},
+	models.RoleBanned:   {},
+}
+
+const (
+	memberKeyPrefix = "member:"
+	memberCacheTTL  = 5 * time.Minute
+)
+
+func hasPermission(role models.RoomRole, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleOf resolves the user's role in room: the host by Room.HostID, then
+// an explicit role, then the room's default role.
+func (s *Service) RoleOf(ctx context.Context, room *models.Room, userID string) (models.RoomRole, error) {
+	if room.HostID.String() == userID {
+		return models.RoleHost, nil
+	}
+
+	member, err := s.loadMember(ctx, room.ID.String(), userID)
+	if err != nil {
+		return "", err
+	}
+	return effectiveRole(room, member), nil
+}
+
+func effectiveRole(room *models.Room, member *models.RoomMember) models.RoomRole {
+	switch {
+	case member != nil && member.UserID == room.HostID:
+		return models.RoleHost
+	case member != nil && member.Role != "":
+		return member.Role
+	case room.DefaultRole != "":
+		return room.DefaultRole
+	default:
+		return models.RoleDJ
+	}
+}
+
+// noMember caches the absence of a membership row.
+const noMember = "-"
+
+// loadMember returns the user's membership row, or nil when there is none.
+func (s *Service) loadMember(ctx context.Context, roomID, userID string) (*models.RoomMember, error) {
+	key := memberKeyPrefix + roomID + ":" + userID
+	if cached, err := s.redis.Get(ctx, key).Bytes(); err == nil {
+		if string(cached) == noMember {
+			return nil, nil
+		}
+		var member models.RoomMember
+		if err := json.Unmarshal(cached, &member); err == nil {
+			return &member, nil
+		}
+	}
+
+	member, err := s.db.GetRoomMember(roomID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.redis.Set(ctx, key, noMember, memberCacheTTL)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room member: %w", err)
+	}
+
+	if memberJSON, err := json.Marshal(member); err == nil {
+		s.redis.Set(ctx, key, memberJSON, memberCacheTTL)
+	}
+	return member, nil
+}
+
+func (s *Service) forgetMember(ctx context.Context, roomID, userID string) {
+	s.redis.Del(ctx, memberKeyPrefix+roomID+":"+userID)
+}
+
+// Authorize returns ErrBanned or ErrForbidden unless the user's role in
+// the room grants perm.
+func (s *Service) Authorize(ctx context.Context, roomID, userID string, perm Permission) error {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	_, err = s.authorize(ctx, room, userID, perm)
+	return err
+}
+
+// authorize also requires membership for everything except PermView, so
+// users have to join a room before they can act in it. Private rooms
+// require it for PermView too, so only members can see inside them.
+// Closed rooms are read-only for everyone.
+func (s *Service) authorize(ctx context.Context, room *models.Room, userID string, perm Permission) (models.RoomRole, error) {
+	if perm != PermView && !room.Active {
+		return "", ErrRoomClosed
+	}
+	if room.HostID.String() == userID {
+		return models.RoleHost, nil
+	}
+
+	member, err := s.loadMember(ctx, room.ID.String(), userID)
+	if err != nil {
+		return "", err
+	}
+
+	role := effectiveRole(room, member)
+	if role == models.RoleBanned {
+		return role, ErrBanned
+	}
+	if (perm != PermView || room.Private) && (member == nil || !member.IsMember()) {
+		return role, ErrNotMember
+	}
+	if !hasPermission(role, perm) {
+		return role, fmt.Errorf("%w: %s cannot %s", ErrForbidden, role, perm)
+	}
+	return role, nil
+}
+
+// RequireMember returns ErrNotMember unless the user has joined the room
+// (the host always has), ErrBanned if they are banned, or ErrRoomClosed.
+func (s *Service) RequireMember(ctx context.Context, roomID, userID string) error {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if !room.Active {
+		return ErrRoomClosed
+	}
+	if room.HostID.String() == userID {
+		return nil
+	}
+
+	member, err := s.loadMember(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if effectiveRole(room, member) == models.RoleBanned {
+		return ErrBanned
+	}
+	if member == nil || !member.IsMember() {
+		return ErrNotMember
+	}
+	return nil
+}
+
+// roomRoles resolves the role of every contributor in the queue, for
+// rankers that weigh contributors by role.
+func (s *Service) roomRoles(ctx context.Context, room *models.Room, items []*models.QueueItem) (map[uuid.UUID]models.RoomRole, error) {
+	roles := make(map[uuid.UUID]models.RoomRole)
+	for _, item := range items {
+		if _, ok := roles[item.UserID]; ok {
+			continue
+		}
+		role, err := s.RoleOf(ctx, room, item.UserID.String())
+		if err != nil {
+			return nil, err
+		}
+		roles[item.UserID] = role
+	}
+	return roles, nil
+}
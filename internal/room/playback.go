@@ -0,0 +1,542 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+
+	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
+	redislock "github.com/music-queue-system/pkg/redis"
+)
+
+const (
+	playbackKeyPrefix  = "playback:"
+	playbackLockPrefix = "playback:lock:"
+	// playbackDeadlines is a sorted set of playing rooms scored by the unix
+	// millisecond at which their current track ends.
+	playbackDeadlines = "playback:deadlines"
+	// playbackActive is the set of rooms that are playing or paused.
+	playbackActive    = "playback:active"
+	playbackStateTTL  = 24 * time.Hour
+	playbackLockTTL   = 5 * time.Second
+	playbackLockWait  = 2 * time.Second
+	playbackClockTick = time.Second
+)
+
+// NowPlaying returns the room's playback state. Rooms that never started
+// playing are idle.
+func (s *Service) NowPlaying(ctx context.Context, roomID string) (*models.PlaybackState, error) {
+	if _, err := s.GetRoom(ctx, roomID); err != nil {
+		return nil, err
+	}
+	return s.loadPlayback(ctx, roomID)
+}
+
+// StartPlayback starts the next song when the room is idle or ended, and
+// resumes when it is paused.
+func (s *Service) StartPlayback(ctx context.Context, roomID, userID string) (*models.PlaybackState, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermControlPlayback); err != nil {
+		return nil, err
+	}
+
+	var state *models.PlaybackState
+	err := s.withPlaybackLock(ctx, roomID, func(pending *playbackEvents) error {
+		var err error
+		state, err = s.loadPlayback(ctx, roomID)
+		if err != nil {
+			return err
+		}
+
+		switch state.Status {
+		case models.PlaybackPlaying:
+			return ErrAlreadyPlaying
+		case models.PlaybackPaused:
+			return s.resumeLocked(ctx, state, userID, pending)
+		default:
+			return s.startNextLocked(ctx, state, userID, pending)
+		}
+	})
+	return state, err
+}
+
+func (s *Service) PausePlayback(ctx context.Context, roomID, userID string) (*models.PlaybackState, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermControlPlayback); err != nil {
+		return nil, err
+	}
+
+	var state *models.PlaybackState
+	err := s.withPlaybackLock(ctx, roomID, func(pending *playbackEvents) error {
+		var err error
+		state, err = s.loadPlayback(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		if state.Status != models.PlaybackPlaying {
+			return ErrNothingPlaying
+		}
+
+		now := time.Now()
+		state.PositionMs = state.Position(now)
+		state.Status = models.PlaybackPaused
+		state.UpdatedAt = now
+		if err := s.savePlayback(ctx, state); err != nil {
+			return err
+		}
+
+		pending.playback(events.EventTypePlaybackPaused, state, userID)
+		return nil
+	})
+	return state, err
+}
+
+func (s *Service) ResumePlayback(ctx context.Context, roomID, userID string) (*models.PlaybackState, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermControlPlayback); err != nil {
+		return nil, err
+	}
+
+	var state *models.PlaybackState
+	err := s.withPlaybackLock(ctx, roomID, func(pending *playbackEvents) error {
+		var err error
+		state, err = s.loadPlayback(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		if state.Status != models.PlaybackPaused {
+			return ErrNotPaused
+		}
+		return s.resumeLocked(ctx, state, userID, pending)
+	})
+	return state, err
+}
+
+// SkipTrack ends the current track early and moves on to the next one.
+func (s *Service) SkipTrack(ctx context.Context, roomID, userID string) (*models.PlaybackState, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermSkip); err != nil {
+		return nil, err
+	}
+
+	current, err := s.loadPlayback(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Status != models.PlaybackPlaying && current.Status != models.PlaybackPaused {
+		return nil, ErrNothingPlaying
+	}
+
+	return s.AdvancePlayback(ctx, roomID, current.QueueItemID, true)
+}
+
+// AdvancePlayback finishes the current track and starts the next one;
+// skipped tells whether the track was cut short rather than played to its
+// end. It is a no-op returning the current state if expectedItemID is no
+// longer the track being played, so concurrent callers cannot skip twice.
+func (s *Service) AdvancePlayback(ctx context.Context, roomID string, expectedItemID uuid.UUID, skipped bool) (*models.PlaybackState, error) {
+	var state *models.PlaybackState
+	err := s.withPlaybackLock(ctx, roomID, func(pending *playbackEvents) error {
+		var err error
+		state, err = s.loadPlayback(ctx, roomID)
+		if err != nil {
+			return err
+		}
+
+		if state.QueueItemID != expectedItemID ||
+			(state.Status != models.PlaybackPlaying && state.Status != models.PlaybackPaused) {
+			return nil
+		}
+
+		if err := s.finishCurrentLocked(ctx, state, skipped, pending); err != nil {
+			return err
+		}
+		return s.startNextLocked(ctx, state, "", pending)
+	})
+	return state, err
+}
+
+// RunPlaybackClock advances rooms whose current track has run to its end.
+// Every instance may run it; the playback lock and the expected item check
+// in AdvancePlayback keep it from double-advancing.
+func (s *Service) RunPlaybackClock(ctx context.Context) {
+	ticker := time.NewTicker(playbackClockTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.advanceDueRooms(ctx)
+		}
+	}
+}
+
+func (s *Service) advanceDueRooms(ctx context.Context) {
+	now := time.Now()
+	roomIDs, err := s.redis.ZRangeByScore(ctx, playbackDeadlines, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		log.Printf("Failed to read playback deadlines: %v", err)
+		return
+	}
+
+	for _, roomID := range roomIDs {
+		state, err := s.loadPlayback(ctx, roomID)
+		if err != nil {
+			log.Printf("Failed to load playback for room %s: %v", roomID, err)
+			continue
+		}
+
+		if state.Status != models.PlaybackPlaying {
+			s.redis.ZRem(ctx, playbackDeadlines, roomID)
+			continue
+		}
+		if now.Before(state.EndsAt()) {
+			continue
+		}
+
+		if _, err := s.AdvancePlayback(ctx, roomID, state.QueueItemID, false); err != nil && !errors.Is(err, ErrPlaybackBusy) {
+			log.Printf("Failed to advance playback for room %s: %v", roomID, err)
+		}
+	}
+}
+
+// stopPlayback returns the room to idle without finishing the current
+// track.
+func (s *Service) stopPlayback(ctx context.Context, roomID string) error {
+	return s.withPlaybackLock(ctx, roomID, func(pending *playbackEvents) error {
+		state, err := s.loadPlayback(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		if state.Status == models.PlaybackIdle {
+			return nil
+		}
+
+		stopped := &models.PlaybackState{RoomID: state.RoomID, Status: models.PlaybackIdle, UpdatedAt: time.Now()}
+		return s.savePlayback(ctx, stopped)
+	})
+}
+
+// pruneActiveScript returns the members of the active set KEYS[1] whose
+// playback state, under the key prefix ARGV[1], still exists, and drops
+// the rest. A state that expired was never stopped, so nothing else would
+// remove its room. It runs as one script so a room saved meanwhile is not
+// dropped.
+var pruneActiveScript = redis.NewScript(`
+local active = {}
+for _, roomID in ipairs(redis.call("smembers", KEYS[1])) do
+	if redis.call("exists", ARGV[1] .. roomID) == 1 then
+		table.insert(active, roomID)
+	else
+		redis.call("srem", KEYS[1], roomID)
+	end
+end
+return active
+`)
+
+// ActivePlaybackRooms lists the rooms that are currently playing or paused.
+func (s *Service) ActivePlaybackRooms(ctx context.Context) ([]string, error) {
+	roomIDs, err := pruneActiveScript.Run(ctx, s.redis, []string{playbackActive}, playbackKeyPrefix).StringSlice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active rooms: %w", err)
+	}
+	return roomIDs, nil
+}
+
+func (s *Service) resumeLocked(ctx context.Context, state *models.PlaybackState, userID string, pending *playbackEvents) error {
+	now := time.Now()
+	state.Status = models.PlaybackPlaying
+	state.ResumedAt = now
+	state.UpdatedAt = now
+	if err := s.savePlayback(ctx, state); err != nil {
+		return err
+	}
+
+	pending.playback(events.EventTypePlaybackResumed, state, userID)
+	return nil
+}
+
+// finishCurrentLocked marks the current track as played and records it in
+// the room's history.
+func (s *Service) finishCurrentLocked(ctx context.Context, state *models.PlaybackState, skipped bool, pending *playbackEvents) error {
+	play := playRecord(state, time.Now(), skipped)
+	// An item deleted while playing has nothing left to record
+	if err := s.db.FinishPlay(play); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("failed to mark song played: %w", err)
+	}
+
+	pending.add(events.EventTypeSongCompleted, "", events.SongCompletedPayload{
+		QueueItemID: state.QueueItemID.String(),
+		TrackID:     state.TrackID,
+		PlayedMs:    play.PlayedMs,
+		Skipped:     play.Skipped,
+	})
+
+	return nil
+}
+
+// playRecord builds the history entry for the current track ending at now.
+// A track that was not skipped played to its end, even when the device
+// finished it a little ahead of the room clock.
+func playRecord(state *models.PlaybackState, now time.Time, skipped bool) *models.PlayHistory {
+	playedMs := state.DurationMs
+	if skipped {
+		playedMs = state.Position(now)
+	}
+	return &models.PlayHistory{
+		ID:          uuid.New(),
+		RoomID:      state.RoomID,
+		QueueItemID: state.QueueItemID,
+		DurationMs:  state.DurationMs,
+		PlayedMs:    playedMs,
+		Skipped:     skipped,
+		StartedAt:   state.StartedAt,
+		EndedAt:     now,
+	}
+}
+
+// startNextLocked moves state to the top of the queue, or to ended when
+// the queue is empty. Tracks Spotify does not know are marked played and
+// skipped over.
+func (s *Service) startNextLocked(ctx context.Context, state *models.PlaybackState, userID string, pending *playbackEvents) error {
+	roomID := state.RoomID.String()
+	for {
+		next, err := s.GetNextSong(ctx, roomID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if next == nil {
+			wasPlaying := state.QueueItemID != uuid.Nil
+			*state = models.PlaybackState{
+				RoomID:    state.RoomID,
+				Status:    models.PlaybackEnded,
+				UpdatedAt: now,
+			}
+			if !wasPlaying {
+				return ErrQueueEmpty
+			}
+			if err := s.savePlayback(ctx, state); err != nil {
+				return err
+			}
+			pending.playback(events.EventTypePlaybackEnded, state, userID)
+			return nil
+		}
+
+		durationMs, err := s.trackDuration(ctx, next)
+		if errors.Is(err, ErrUnknownTrack) {
+			log.Printf("Skipping unplayable track %s in room %s: %v", next.TrackID, roomID, err)
+			if err := s.db.TakeQueueHead(roomID, next.ID.String(), &now); err != nil {
+				return fmt.Errorf("failed to mark song played: %w", err)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if room, err := s.GetRoom(ctx, roomID); err == nil && room.Ranking == models.RankingRotation {
+			if err := s.recordRotationTurn(ctx, room, next); err != nil {
+				log.Printf("Failed to record rotation turn for room %s: %v", roomID, err)
+			}
+		}
+
+		*state = models.PlaybackState{
+			RoomID:      state.RoomID,
+			Status:      models.PlaybackPlaying,
+			QueueItemID: next.ID,
+			TrackID:     next.TrackID,
+			TrackName:   next.TrackName,
+			Artist:      next.Artist,
+			AddedBy:     next.UserID,
+			DurationMs:  durationMs,
+			StartedAt:   now,
+			ResumedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := s.savePlayback(ctx, state); err != nil {
+			return err
+		}
+		// Only once next is playing has it left the queue, so the pins
+		// behind it move up exactly once however often a start is retried
+		if err := s.db.TakeQueueHead(roomID, next.ID.String(), nil); err != nil {
+			log.Printf("Failed to shift pinned songs in room %s: %v", roomID, err)
+		}
+		s.touch(ctx, roomID)
+
+		pending.add(events.EventTypeSongStarted, userID, events.SongStartedPayload{
+			QueueItemID: next.ID.String(),
+			TrackID:     next.TrackID,
+			TrackName:   next.TrackName,
+			Artist:      next.Artist,
+			DurationMs:  durationMs,
+			StartedAt:   now,
+		})
+		return nil
+	}
+}
+
+// trackDuration returns the item's duration, looking it up for items
+// queued before metadata was stored.
+func (s *Service) trackDuration(ctx context.Context, item *models.QueueItem) (int, error) {
+	if item.DurationMs > 0 {
+		return item.DurationMs, nil
+	}
+
+	track, err := s.lookupTrack(ctx, item.TrackID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.db.SetQueueItemDuration(item.ID.String(), track.Duration); err != nil {
+		log.Printf("Warning: failed to store track duration: %v", err)
+	}
+	item.DurationMs = track.Duration
+	return track.Duration, nil
+}
+
+// playbackEvents collects the events of a state transition so they can be
+// published once the playback lock is released; Kafka writes are too slow
+// to make while holding it.
+type playbackEvents []pendingEvent
+
+type pendingEvent struct {
+	eventType events.EventType
+	userID    string
+	payload   interface{}
+}
+
+func (p *playbackEvents) add(eventType events.EventType, userID string, payload interface{}) {
+	*p = append(*p, pendingEvent{eventType: eventType, userID: userID, payload: payload})
+}
+
+func (p *playbackEvents) playback(eventType events.EventType, state *models.PlaybackState, userID string) {
+	payload := events.PlaybackPayload{
+		PositionMs: state.PositionMs,
+	}
+	if state.QueueItemID != uuid.Nil {
+		payload.QueueItemID = state.QueueItemID.String()
+	}
+	p.add(eventType, userID, payload)
+}
+
+func (s *Service) publishPending(ctx context.Context, roomID string, pending playbackEvents) {
+	for _, e := range pending {
+		if err := s.events.PublishRoomEvent(ctx, e.eventType, roomID, e.userID, e.payload); err != nil {
+			log.Printf("Failed to publish %s event: %v", e.eventType, err)
+		}
+	}
+}
+
+func (s *Service) loadPlayback(ctx context.Context, roomID string) (*models.PlaybackState, error) {
+	stateJSON, err := s.redis.Get(ctx, playbackKeyPrefix+roomID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		id, err := uuid.Parse(roomID)
+		if err != nil {
+			return nil, ErrRoomNotFound
+		}
+		return &models.PlaybackState{RoomID: id, Status: models.PlaybackIdle}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playback state: %w", err)
+	}
+
+	var state models.PlaybackState
+	if err := json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal playback state: %w", err)
+	}
+	return &state, nil
+}
+
+// savePlayback stores state and keeps the deadline index in step with it.
+func (s *Service) savePlayback(ctx context.Context, state *models.PlaybackState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal playback state: %w", err)
+	}
+
+	roomID := state.RoomID.String()
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, playbackKeyPrefix+roomID, stateJSON, playbackStateTTL)
+	if state.Status == models.PlaybackPlaying {
+		pipe.ZAdd(ctx, playbackDeadlines, redis.Z{Score: float64(state.EndsAt().UnixMilli()), Member: roomID})
+	} else {
+		pipe.ZRem(ctx, playbackDeadlines, roomID)
+	}
+	if state.Status == models.PlaybackPlaying || state.Status == models.PlaybackPaused {
+		pipe.SAdd(ctx, playbackActive, roomID)
+	} else {
+		pipe.SRem(ctx, playbackActive, roomID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save playback state: %w", err)
+	}
+	return nil
+}
+
+// withPlaybackLock serialises state transitions for a room across instances.
+// The lock is refreshed while fn runs, since finding the next track can
+// mean several database writes and Spotify lookups. Events fn collects are
+// published after the lock is released, including those of a transition
+// that failed part way.
+func (s *Service) withPlaybackLock(ctx context.Context, roomID string, fn func(pending *playbackEvents) error) error {
+	waitCtx, cancel := context.WithTimeout(ctx, playbackLockWait)
+	defer cancel()
+
+	lock, err := redislock.AcquireLock(waitCtx, s.redis, playbackLockPrefix+roomID, playbackLockTTL, 50*time.Millisecond)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return ErrPlaybackBusy
+		}
+		return err
+	}
+
+	var pending playbackEvents
+	err = func() error {
+		defer lock.Release(context.Background())
+		defer keepLock(lock, roomID)()
+		return fn(&pending)
+	}()
+
+	s.publishPending(ctx, roomID, pending)
+	return err
+}
+
+// keepLock refreshes lock until the returned stop function is called.
+func keepLock(lock *redislock.Lock, roomID string) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(playbackLockTTL / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := lock.Refresh(context.Background()); err != nil {
+					log.Printf("Failed to keep playback lock for room %s: %v", roomID, err)
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,50 @@
+package room
+
+import (
+	"testing"
+	"time"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+func TestPlayRecord(t *testing.T) {
+	const durationMs = 180000
+	started := rankBase
+
+	tests := []struct {
+		name        string
+		endedAfter  time.Duration
+		skipped     bool
+		wantPlayed  int
+		wantSkipped bool
+	}{
+		// The driver advances up to endTolerance before the room clock runs out
+		{name: "natural end inside tolerance", endedAfter: durationMs*time.Millisecond - 2*time.Second, wantPlayed: durationMs},
+		{name: "natural end on the clock", endedAfter: durationMs * time.Millisecond, wantPlayed: durationMs},
+		{name: "skipped part way", endedAfter: time.Minute, skipped: true, wantPlayed: 60000, wantSkipped: true},
+		{name: "skipped near the end", endedAfter: durationMs*time.Millisecond - time.Second, skipped: true, wantPlayed: durationMs - 1000, wantSkipped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &models.PlaybackState{
+				Status:     models.PlaybackPlaying,
+				DurationMs: durationMs,
+				StartedAt:  started,
+				ResumedAt:  started,
+			}
+			now := started.Add(tt.endedAfter)
+
+			play := playRecord(state, now, tt.skipped)
+			if play.Skipped != tt.wantSkipped {
+				t.Errorf("Skipped = %v, want %v", play.Skipped, tt.wantSkipped)
+			}
+			if play.PlayedMs != tt.wantPlayed {
+				t.Errorf("PlayedMs = %d, want %d", play.PlayedMs, tt.wantPlayed)
+			}
+			if !play.EndedAt.Equal(now) {
+				t.Errorf("EndedAt = %v, want %v", play.EndedAt, now)
+			}
+		})
+	}
+}
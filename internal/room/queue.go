@@ -0,0 +1,187 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
+)
+
+// RemoveFromQueue deletes an upcoming song. Users may withdraw their own
+// songs; removing anyone else's needs PermRemoveSong. The song playing now
+// has to be skipped instead.
+func (s *Service) RemoveFromQueue(ctx context.Context, roomID, userID, itemID string) error {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	item, err := s.queueItem(ctx, roomID, itemID)
+	if err != nil {
+		return err
+	}
+
+	perm := PermRemoveSong
+	if item.UserID.String() == userID {
+		perm = PermAddSong
+	}
+	if _, err := s.authorize(ctx, room, userID, perm); err != nil {
+		return err
+	}
+
+	queue, err := s.GetQueue(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	index := queueIndex(queue, item.ID)
+	if index < 0 {
+		return ErrQueueItemPlaying
+	}
+
+	removed, err := s.db.RemoveQueueItem(roomID, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to remove queue item: %w", err)
+	}
+	if !removed {
+		return ErrQueueItemNotFound
+	}
+
+	// Pins behind the removed song move up to keep their place in line
+	if err := s.db.ShiftPins(roomID, index+1, -1); err != nil {
+		log.Printf("Failed to shift pinned songs in room %s: %v", roomID, err)
+	}
+
+	payload := events.SongRemovedPayload{
+		QueueItemID: itemID,
+		TrackID:     item.TrackID,
+		RemovedBy:   userID,
+	}
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeSongRemoved, roomID, userID, payload); err != nil {
+		log.Printf("Failed to publish song removed event: %v", err)
+	}
+
+	return nil
+}
+
+// MoveQueueItem pins an upcoming song at position, 0 being the next song
+// to play, overriding the room's ranking for it.
+func (s *Service) MoveQueueItem(ctx context.Context, roomID, userID, itemID string, position int) ([]*models.QueueItem, error) {
+	if position < 0 {
+		return nil, fmt.Errorf("%w: position cannot be negative", ErrInvalidPosition)
+	}
+	if err := s.Authorize(ctx, roomID, userID, PermReorder); err != nil {
+		return nil, err
+	}
+
+	item, err := s.queueItem(ctx, roomID, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := s.GetQueue(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if queueIndex(queue, item.ID) < 0 {
+		return nil, ErrQueueItemPlaying
+	}
+	if position >= len(queue) {
+		position = len(queue) - 1
+	}
+
+	err = s.db.PinQueueItem(roomID, itemID, position)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQueueItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to move queue item: %w", err)
+	}
+
+	return s.publishReorder(ctx, roomID, userID, itemID, true, position)
+}
+
+// PlayNext pins an upcoming song to play after the current one.
+func (s *Service) PlayNext(ctx context.Context, roomID, userID, itemID string) ([]*models.QueueItem, error) {
+	return s.MoveQueueItem(ctx, roomID, userID, itemID, 0)
+}
+
+// UnpinQueueItem hands a pinned song back to the room's ranking.
+func (s *Service) UnpinQueueItem(ctx context.Context, roomID, userID, itemID string) ([]*models.QueueItem, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermReorder); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.queueItem(ctx, roomID, itemID); err != nil {
+		return nil, err
+	}
+
+	err := s.db.UnpinQueueItem(roomID, itemID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQueueItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpin queue item: %w", err)
+	}
+
+	return s.publishReorder(ctx, roomID, userID, itemID, false, 0)
+}
+
+// queueItem loads an unplayed item of the room.
+func (s *Service) queueItem(ctx context.Context, roomID, itemID string) (*models.QueueItem, error) {
+	if _, err := uuid.Parse(itemID); err != nil {
+		return nil, ErrQueueItemNotFound
+	}
+
+	item, err := s.db.GetQueueItem(itemID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQueueItemNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue item: %w", err)
+	}
+	if item.RoomID.String() != roomID || item.Played {
+		return nil, ErrQueueItemNotFound
+	}
+	return item, nil
+}
+
+// publishReorder announces a pin change with the new queue order, which it
+// returns.
+func (s *Service) publishReorder(ctx context.Context, roomID, userID, itemID string, pinned bool, position int) ([]*models.QueueItem, error) {
+	queue, err := s.GetQueue(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]string, len(queue))
+	for i, item := range queue {
+		order[i] = item.ID.String()
+	}
+
+	payload := events.QueueReorderedPayload{
+		QueueItemID: itemID,
+		Pinned:      pinned,
+		Position:    position,
+		Order:       order,
+	}
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeQueueReordered, roomID, userID, payload); err != nil {
+		log.Printf("Failed to publish queue reordered event: %v", err)
+	}
+
+	return queue, nil
+}
+
+func queueIndex(queue []*models.QueueItem, id uuid.UUID) int {
+	for i, item := range queue {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
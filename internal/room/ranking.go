@@ -0,0 +1,351 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+// QueueState is everything a Ranker may base its order on.
+type QueueState struct {
+	Room    *models.Room
+	Items   []*models.QueueItem
+	Tallies map[uuid.UUID]models.VoteTally
+	// Credits is the DJ rotation's carried-over turn credit per
+	// contributor and Roles each contributor's role. They are only loaded
+	// for rooms in rotation mode.
+	Credits map[uuid.UUID]int
+	Roles   map[uuid.UUID]models.RoomRole
+	Now     time.Time
+}
+
+// Ranker orders a room's unplayed queue, the item to play next first.
+// Implementations must not modify q.Items.
+type Ranker interface {
+	Rank(q *QueueState) []*models.QueueItem
+}
+
+var rankers = map[string]Ranker{
+	models.RankingVotes:      votesRanker{},
+	models.RankingWilson:     wilsonRanker{},
+	models.RankingHot:        hotRanker{},
+	models.RankingRoundRobin: roundRobinRanker{},
+	models.RankingFIFO:       fifoRanker{},
+	models.RankingRotation:   rotationRanker{},
+}
+
+// rankerFor returns the room's ranker, falling back to net votes for rooms
+// created before the setting existed.
+func rankerFor(room *models.Room) Ranker {
+	if r, ok := rankers[room.Ranking]; ok {
+		return r
+	}
+	return votesRanker{}
+}
+
+// sortedBy returns a copy of items ordered by score descending, oldest
+// first among equal scores.
+func sortedBy(items []*models.QueueItem, score func(*models.QueueItem) float64) []*models.QueueItem {
+	ranked := make([]*models.QueueItem, len(items))
+	copy(ranked, items)
+	scores := make(map[uuid.UUID]float64, len(items))
+	for _, item := range items {
+		scores[item.ID] = score(item)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		si, sj := scores[ranked[i].ID], scores[ranked[j].ID]
+		if si != sj {
+			return si > sj
+		}
+		return ranked[i].CreatedAt.Before(ranked[j].CreatedAt)
+	})
+	return ranked
+}
+
+// applyPins moves pinned items from the ranked order to their positions.
+// Pins beyond the end of the queue keep their relative order at the end.
+func applyPins(ranked []*models.QueueItem) []*models.QueueItem {
+	var pinned, order []*models.QueueItem
+	for _, item := range ranked {
+		if item.Pinned {
+			pinned = append(pinned, item)
+		} else {
+			order = append(order, item)
+		}
+	}
+	if len(pinned) == 0 {
+		return ranked
+	}
+
+	sort.SliceStable(pinned, func(i, j int) bool {
+		return pinned[i].Position < pinned[j].Position
+	})
+	for _, item := range pinned {
+		pos := item.Position
+		if pos > len(order) {
+			pos = len(order)
+		}
+		order = append(order[:pos], append([]*models.QueueItem{item}, order[pos:]...)...)
+	}
+	return order
+}
+
+func withoutItem(items []*models.QueueItem, id uuid.UUID) []*models.QueueItem {
+	for i, item := range items {
+		if item.ID == id {
+			return append(items[:i:i], items[i+1:]...)
+		}
+	}
+	return items
+}
+
+// votesRanker orders by net votes.
+type votesRanker struct{}
+
+func (votesRanker) Rank(q *QueueState) []*models.QueueItem {
+	return sortedBy(q.Items, func(item *models.QueueItem) float64 {
+		return float64(item.Votes)
+	})
+}
+
+// wilsonRanker orders by the lower bound of the Wilson score interval for
+// the share of up votes, so a 10-1 item beats a 1-0 item. Super-votes
+// count as their weight.
+type wilsonRanker struct{}
+
+// wilsonZ is the z-score for 95% confidence.
+const wilsonZ = 1.96
+
+func (wilsonRanker) Rank(q *QueueState) []*models.QueueItem {
+	return sortedBy(q.Items, func(item *models.QueueItem) float64 {
+		tally := q.Tallies[item.ID]
+		return wilsonLowerBound(tally.WeightedUp, tally.WeightedDown)
+	})
+}
+
+func wilsonLowerBound(up, down int) float64 {
+	n := float64(up + down)
+	if n == 0 {
+		return 0
+	}
+
+	p := float64(up) / n
+	z2 := wilsonZ * wilsonZ
+	return (p + z2/(2*n) - wilsonZ*math.Sqrt((p*(1-p)+z2/(4*n))/n)) / (1 + z2/n)
+}
+
+// hotRanker uses Reddit's hot score: the order of magnitude of the net
+// votes plus a bonus for recency, so every 12.5 hours of age is worth a
+// factor of ten in votes.
+type hotRanker struct{}
+
+const hotDecaySeconds = 45000
+
+func (hotRanker) Rank(q *QueueState) []*models.QueueItem {
+	return sortedBy(q.Items, func(item *models.QueueItem) float64 {
+		order := math.Log10(math.Max(math.Abs(float64(item.Votes)), 1))
+		sign := 0.0
+		if item.Votes > 0 {
+			sign = 1
+		} else if item.Votes < 0 {
+			sign = -1
+		}
+		return sign*order + float64(item.CreatedAt.Unix())/hotDecaySeconds
+	})
+}
+
+// roundRobinRanker takes one song from each contributor in turn, each
+// contributor's best voted song first. Contributors go in the order they
+// first added a song.
+type roundRobinRanker struct{}
+
+func (roundRobinRanker) Rank(q *QueueState) []*models.QueueItem {
+	order, perUser := contributors(q)
+
+	ranked := make([]*models.QueueItem, 0, len(q.Items))
+	for len(ranked) < len(q.Items) {
+		for _, userID := range order {
+			if queue := perUser[userID]; len(queue) > 0 {
+				ranked = append(ranked, queue[0])
+				perUser[userID] = queue[1:]
+			}
+		}
+	}
+	return ranked
+}
+
+// contributors groups the queue by who added each song, every group in
+// net vote order, and lists the contributors in the order they first
+// added a song.
+func contributors(q *QueueState) ([]uuid.UUID, map[uuid.UUID][]*models.QueueItem) {
+	var order []uuid.UUID
+	firstAdded := make(map[uuid.UUID]time.Time)
+	perUser := make(map[uuid.UUID][]*models.QueueItem)
+	for _, item := range (votesRanker{}).Rank(q) {
+		if _, ok := perUser[item.UserID]; !ok {
+			order = append(order, item.UserID)
+		}
+		perUser[item.UserID] = append(perUser[item.UserID], item)
+		if t, ok := firstAdded[item.UserID]; !ok || item.CreatedAt.Before(t) {
+			firstAdded[item.UserID] = item.CreatedAt
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return firstAdded[order[i]].Before(firstAdded[order[j]])
+	})
+	return order, perUser
+}
+
+// rotationRanker is the DJ rotation: contributors take turns playing
+// their best voted song, and a contributor with weight n gets n turns for
+// every turn of a weight 1 contributor. Turns are handed out by smooth
+// weighted round robin; the credits it carries between turns are stored
+// per room (see recordRotationTurn) so the rotation continues where it
+// left off after every song instead of restarting.
+type rotationRanker struct{}
+
+func (rotationRanker) Rank(q *QueueState) []*models.QueueItem {
+	order, perUser := contributors(q)
+
+	credits := make(map[uuid.UUID]int, len(order))
+	for _, userID := range order {
+		credits[userID] = q.Credits[userID]
+	}
+
+	ranked := make([]*models.QueueItem, 0, len(q.Items))
+	for len(ranked) < len(q.Items) {
+		active := make([]uuid.UUID, 0, len(order))
+		for _, userID := range order {
+			if len(perUser[userID]) > 0 {
+				active = append(active, userID)
+			}
+		}
+
+		next := nextRotationTurn(q, active, credits)
+		ranked = append(ranked, perUser[next][0])
+		perUser[next] = perUser[next][1:]
+	}
+	return ranked
+}
+
+// nextRotationTurn performs one smooth weighted round robin step over the
+// active contributors and returns whose turn it is, updating credits.
+// Ties go to the contributor listed first.
+func nextRotationTurn(q *QueueState, active []uuid.UUID, credits map[uuid.UUID]int) uuid.UUID {
+	total := 0
+	var next uuid.UUID
+	for i, userID := range active {
+		weight := rotationWeight(q, userID)
+		total += weight
+		credits[userID] += weight
+		if i == 0 || credits[userID] > credits[next] {
+			next = userID
+		}
+	}
+	credits[next] -= total
+	return next
+}
+
+// rotationWeight looks a contributor's weight up by user ID, then by role,
+// defaulting to 1.
+func rotationWeight(q *QueueState, userID uuid.UUID) int {
+	if w, ok := q.Room.RotationWeights[userID.String()]; ok {
+		return w
+	}
+	if w, ok := q.Room.RotationWeights[string(q.Roles[userID])]; ok {
+		return w
+	}
+	return 1
+}
+
+// fifoRanker plays songs in the order they were added, ignoring votes.
+type fifoRanker struct{}
+
+func (fifoRanker) Rank(q *QueueState) []*models.QueueItem {
+	return sortedBy(q.Items, func(*models.QueueItem) float64 { return 0 })
+}
+
+const (
+	rotationKeyPrefix = "rotation:"
+	// rotationStateTTL matches the lifetime of the room's playback state,
+	// whose song starts are what advance the rotation.
+	rotationStateTTL = playbackStateTTL
+)
+
+func (s *Service) rotationCredits(ctx context.Context, roomID string) (map[uuid.UUID]int, error) {
+	raw, err := s.redis.HGetAll(ctx, rotationKeyPrefix+roomID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rotation state: %w", err)
+	}
+
+	credits := make(map[uuid.UUID]int, len(raw))
+	for userID, credit := range raw {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.Atoi(credit); err == nil {
+			credits[id] = n
+		}
+	}
+	return credits, nil
+}
+
+// recordRotationTurn advances the stored DJ rotation when item starts
+// playing, so the next projection starts from the following turn. item
+// must still be in the pending queue.
+func (s *Service) recordRotationTurn(ctx context.Context, room *models.Room, item *models.QueueItem) error {
+	credits, err := s.rotationCredits(ctx, room.ID.String())
+	if err != nil {
+		return err
+	}
+
+	queue, err := s.db.GetQueue(room.ID.String())
+	if err != nil {
+		return fmt.Errorf("failed to get queue: %w", err)
+	}
+	roles, err := s.roomRoles(ctx, room, queue)
+	if err != nil {
+		return err
+	}
+	q := &QueueState{Room: room, Items: queue, Roles: roles}
+	active, _ := contributors(q)
+
+	if chosen := nextRotationTurn(q, active, credits); chosen != item.UserID {
+		// The song played out of turn; charge the turn to the contributor
+		// who actually played.
+		total := 0
+		for _, userID := range active {
+			total += rotationWeight(q, userID)
+		}
+		credits[chosen] += total
+		credits[item.UserID] -= total
+	}
+
+	// Contributors with nothing queued drop out of the rotation and start
+	// fresh when they come back.
+	fields := make(map[string]interface{}, len(active))
+	for _, userID := range active {
+		fields[userID.String()] = credits[userID]
+	}
+
+	key := rotationKeyPrefix + room.ID.String()
+	pipe := s.redis.TxPipeline()
+	pipe.Del(ctx, key)
+	if len(fields) > 0 {
+		pipe.HSet(ctx, key, fields)
+		pipe.Expire(ctx, key, rotationStateTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save rotation state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package room
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+var (
+	rankBase = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	alice    = uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+	bob      = uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+	carol    = uuid.MustParse("00000000-0000-0000-0000-00000000000c")
+)
+
+// item builds a queue item named by its track ID, added minute minutes
+// after rankBase.
+func item(name string, user uuid.UUID, votes, minute int) *models.QueueItem {
+	return &models.QueueItem{
+		ID:        uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)),
+		TrackID:   name,
+		UserID:    user,
+		Votes:     votes,
+		CreatedAt: rankBase.Add(time.Duration(minute) * time.Minute),
+	}
+}
+
+func names(items []*models.QueueItem) string {
+	out := make([]string, len(items))
+	for i, it := range items {
+		out[i] = it.TrackID
+	}
+	return strings.Join(out, ",")
+}
+
+func TestRankers(t *testing.T) {
+	a := item("a", alice, 3, 0)
+	b := item("b", alice, 5, 1)
+	c := item("c", bob, 5, 2)
+	d := item("d", bob, -1, 3)
+	e := item("e", carol, 0, 4)
+	items := []*models.QueueItem{a, b, c, d, e}
+
+	tests := []struct {
+		ranking string
+		tallies map[uuid.UUID]models.VoteTally
+		want    string
+	}{
+		// Ties go to the older item
+		{ranking: models.RankingVotes, want: "b,c,a,e,d"},
+		{ranking: models.RankingFIFO, want: "a,b,c,d,e"},
+		// Contributors in the order they first added a song, each
+		// playing their best voted song first
+		{ranking: models.RankingRoundRobin, want: "b,c,e,a,d"},
+		{
+			ranking: models.RankingWilson,
+			tallies: map[uuid.UUID]models.VoteTally{
+				a.ID: {WeightedUp: 10, WeightedDown: 1},
+				b.ID: {WeightedUp: 1},
+				c.ID: {WeightedUp: 3, WeightedDown: 3},
+			},
+			want: "a,b,c,d,e",
+		},
+		{ranking: "unknown", want: "b,c,a,e,d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ranking, func(t *testing.T) {
+			room := &models.Room{Ranking: tt.ranking}
+			q := &QueueState{Room: room, Items: items, Tallies: tt.tallies, Now: rankBase}
+
+			got := rankerFor(room).Rank(q)
+			if names(got) != tt.want {
+				t.Errorf("order = %s, want %s", names(got), tt.want)
+			}
+			if names(items) != "a,b,c,d,e" {
+				t.Errorf("ranker modified the input to %s", names(items))
+			}
+		})
+	}
+}
+
+func TestHotRankerFavoursRecentSongs(t *testing.T) {
+	// Every 12.5 hours of age cost a factor of ten in votes, so a 100 vote
+	// song is overtaken by a 1 vote song added 25 hours later
+	old := item("old", alice, 100, 0)
+	recent := item("recent", bob, 1, 0)
+	recent.CreatedAt = old.CreatedAt.Add(26 * time.Hour)
+	slightlyNewer := item("newer", carol, 1, 0)
+	slightlyNewer.CreatedAt = old.CreatedAt.Add(time.Hour)
+
+	q := &QueueState{Items: []*models.QueueItem{old, slightlyNewer, recent}}
+	if got := names((hotRanker{}).Rank(q)); got != "recent,old,newer" {
+		t.Errorf("order = %s, want recent,old,newer", got)
+	}
+}
+
+func TestWilsonLowerBound(t *testing.T) {
+	tests := []struct {
+		up, down int
+		want     float64
+	}{
+		{0, 0, 0},
+		{1, 0, 0.2065},
+		{10, 1, 0.6226},
+		{0, 5, 0},
+		{50, 50, 0.4038},
+	}
+
+	for _, tt := range tests {
+		got := wilsonLowerBound(tt.up, tt.down)
+		if math.Abs(got-tt.want) > 0.0005 {
+			t.Errorf("wilsonLowerBound(%d, %d) = %.4f, want %.4f", tt.up, tt.down, got, tt.want)
+		}
+	}
+
+	if wilsonLowerBound(10, 1) <= wilsonLowerBound(1, 0) {
+		t.Error("10-1 does not beat 1-0")
+	}
+}
+
+func TestNextRotationTurn(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		roles   map[uuid.UUID]models.RoomRole
+		credits map[uuid.UUID]int
+		active  []uuid.UUID
+		want    []uuid.UUID
+	}{
+		{
+			name:   "equal weights alternate",
+			active: []uuid.UUID{alice, bob},
+			want:   []uuid.UUID{alice, bob, alice, bob},
+		},
+		{
+			name:    "weight by user ID",
+			weights: map[string]int{alice.String(): 2},
+			active:  []uuid.UUID{alice, bob},
+			want:    []uuid.UUID{alice, bob, alice, alice, bob, alice},
+		},
+		{
+			name:    "weight by role",
+			weights: map[string]int{string(models.RoleDJ): 2},
+			roles:   map[uuid.UUID]models.RoomRole{bob: models.RoleDJ},
+			active:  []uuid.UUID{alice, bob},
+			want:    []uuid.UUID{bob, alice, bob, bob, alice, bob},
+		},
+		{
+			name:    "carried credit continues the rotation",
+			credits: map[uuid.UUID]int{alice: -1, bob: 1},
+			active:  []uuid.UUID{alice, bob},
+			want:    []uuid.UUID{bob, alice, bob},
+		},
+		{
+			name:   "single contributor",
+			active: []uuid.UUID{carol},
+			want:   []uuid.UUID{carol, carol},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &QueueState{Room: &models.Room{RotationWeights: tt.weights}, Roles: tt.roles}
+			credits := make(map[uuid.UUID]int)
+			for id, c := range tt.credits {
+				credits[id] = c
+			}
+
+			for i, want := range tt.want {
+				if got := nextRotationTurn(q, tt.active, credits); got != want {
+					t.Fatalf("turn %d = %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotationRanker(t *testing.T) {
+	// Alice has weight 2; within a contributor, better voted songs go first
+	items := []*models.QueueItem{
+		item("a1", alice, 0, 0),
+		item("a2", alice, 1, 1),
+		item("a3", alice, 0, 2),
+		item("b1", bob, 0, 3),
+		item("b2", bob, 0, 4),
+	}
+	room := &models.Room{Ranking: models.RankingRotation, RotationWeights: map[string]int{alice.String(): 2}}
+	q := &QueueState{Room: room, Items: items, Credits: map[uuid.UUID]int{}}
+
+	if got := names(rankerFor(room).Rank(q)); got != "a2,b1,a1,a3,b2" {
+		t.Errorf("order = %s, want a2,b1,a1,a3,b2", got)
+	}
+}
+
+func TestApplyPins(t *testing.T) {
+	pin := func(name string, position int) *models.QueueItem {
+		it := item(name, alice, 0, 0)
+		it.Pinned = true
+		it.Position = position
+		return it
+	}
+	a, b, c := item("a", alice, 0, 0), item("b", bob, 0, 1), item("c", carol, 0, 2)
+
+	tests := []struct {
+		name   string
+		ranked []*models.QueueItem
+		want   string
+	}{
+		{"no pins", []*models.QueueItem{a, b, c}, "a,b,c"},
+		{"pin to the front", []*models.QueueItem{a, b, pin("p", 0), c}, "p,a,b,c"},
+		{"pin in the middle", []*models.QueueItem{pin("p", 1), a, b, c}, "a,p,b,c"},
+		{"pins in position order", []*models.QueueItem{pin("q", 2), a, pin("p", 0), b}, "p,a,q,b"},
+		{"pins past the end keep their order", []*models.QueueItem{pin("q", 9), a, pin("p", 5)}, "a,p,q"},
+		{"only pins", []*models.QueueItem{pin("q", 1), pin("p", 0)}, "p,q"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(applyPins(tt.ranked)); got != tt.want {
+				t.Errorf("applyPins() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
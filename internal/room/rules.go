@@ -0,0 +1,139 @@
+package room
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/music-queue-system/pkg/database"
+	"github.com/music-queue-system/pkg/models"
+)
+
+// Codes reported when a song breaks one of the room's rules.
+const (
+	RuleDuplicate      = "duplicate_track"
+	RuleRecentlyPlayed = "recently_played"
+	RuleTooLong        = "track_too_long"
+	RuleExplicit       = "explicit_blocked"
+	RuleBannedArtist   = "artist_banned"
+	RuleBannedTrack    = "track_banned"
+	RulePendingLimit   = "pending_limit"
+)
+
+// Upper bounds for the numeric rules a host can set.
+const (
+	maxReplayWindowHours = 7 * 24
+	maxBannedEntries     = 500
+)
+
+// RuleViolation is returned by AddToQueue when a song is rejected by the
+// room's rules. Rule is one of the Rule* codes.
+type RuleViolation struct {
+	Rule    string
+	Message string
+}
+
+func (v *RuleViolation) Error() string {
+	return v.Message
+}
+
+// queueAdder inserts a queue item once a check of the room's queue passes,
+// with no other insert in the room between the two.
+type queueAdder interface {
+	AddToQueue(item *models.QueueItem, check func(q database.QueueReader) error) error
+}
+
+// addChecked queues item in room if it passes the room's rules. The rules
+// that depend on the queue are checked atomically with the insert, so
+// concurrent adds cannot both slip past a limit.
+func addChecked(adder queueAdder, room *models.Room, item *models.QueueItem) error {
+	if err := checkTrackRules(room.Rules, item); err != nil {
+		return err
+	}
+	return adder.AddToQueue(item, func(q database.QueueReader) error {
+		return checkQueueRules(q, room, item)
+	})
+}
+
+// checkTrackRules returns a *RuleViolation if the track itself is not
+// allowed by rules. The item's track metadata must already be resolved.
+func checkTrackRules(rules models.RoomRules, item *models.QueueItem) error {
+	if contains(rules.BannedTracks, item.TrackID) {
+		return &RuleViolation{RuleBannedTrack, "this song is banned in this room"}
+	}
+	if rules.BlockExplicit && item.Explicit {
+		return &RuleViolation{RuleExplicit, "explicit songs are not allowed in this room"}
+	}
+	if rules.MaxDurationSeconds > 0 && item.DurationMs > rules.MaxDurationSeconds*1000 {
+		return &RuleViolation{RuleTooLong, fmt.Sprintf("songs can be at most %s long", time.Duration(rules.MaxDurationSeconds)*time.Second)}
+	}
+	for _, artist := range item.Artists {
+		if contains(rules.BannedArtists, artist.ID) {
+			return &RuleViolation{RuleBannedArtist, fmt.Sprintf("%s is banned in this room", artist.Name)}
+		}
+	}
+	return nil
+}
+
+// checkQueueRules returns a *RuleViolation if item may not join room's
+// queue as q sees it.
+func checkQueueRules(q database.QueueReader, room *models.Room, item *models.QueueItem) error {
+	rules := room.Rules
+	roomID := room.ID.String()
+
+	if !rules.AllowDuplicates {
+		pending, err := q.HasPendingTrack(roomID, item.TrackID)
+		if err != nil {
+			return fmt.Errorf("failed to check for duplicates: %w", err)
+		}
+		if pending {
+			return &RuleViolation{RuleDuplicate, "this song is already in the queue"}
+		}
+	}
+
+	if rules.ReplayWindowHours > 0 {
+		since := time.Now().Add(-time.Duration(rules.ReplayWindowHours) * time.Hour)
+		played, err := q.PlayedSince(roomID, item.TrackID, since)
+		if err != nil {
+			return fmt.Errorf("failed to check play history: %w", err)
+		}
+		if played {
+			return &RuleViolation{RuleRecentlyPlayed, fmt.Sprintf("this song was played in the last %d hours", rules.ReplayWindowHours)}
+		}
+	}
+
+	if rules.MaxPendingPerUser > 0 {
+		pending, err := q.CountPendingByUser(roomID, item.UserID.String())
+		if err != nil {
+			return fmt.Errorf("failed to count queued songs: %w", err)
+		}
+		if pending >= rules.MaxPendingPerUser {
+			return &RuleViolation{RulePendingLimit, fmt.Sprintf("you can have at most %d songs in the queue", rules.MaxPendingPerUser)}
+		}
+	}
+
+	return nil
+}
+
+// validateRules checks rules a host submitted.
+func validateRules(rules models.RoomRules) error {
+	switch {
+	case rules.ReplayWindowHours < 0 || rules.ReplayWindowHours > maxReplayWindowHours:
+		return fmt.Errorf("%w: replay window must be between 0 and %d hours", ErrInvalidSetting, maxReplayWindowHours)
+	case rules.MaxDurationSeconds < 0:
+		return fmt.Errorf("%w: max duration cannot be negative", ErrInvalidSetting)
+	case rules.MaxPendingPerUser < 0:
+		return fmt.Errorf("%w: max pending songs cannot be negative", ErrInvalidSetting)
+	case len(rules.BannedArtists) > maxBannedEntries || len(rules.BannedTracks) > maxBannedEntries:
+		return fmt.Errorf("%w: at most %d banned artists or tracks", ErrInvalidSetting, maxBannedEntries)
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/music-queue-system/pkg/database"
+	"github.com/music-queue-system/pkg/models"
+)
+
+func TestValidateRules(t *testing.T) {
+	tooMany := make([]string, maxBannedEntries+1)
+
+	tests := []struct {
+		name    string
+		rules   models.RoomRules
+		wantErr bool
+	}{
+		{name: "zero value", rules: models.RoomRules{}},
+		{name: "all limits set", rules: models.RoomRules{ReplayWindowHours: maxReplayWindowHours, MaxDurationSeconds: 600, MaxPendingPerUser: 3, BannedArtists: []string{"x"}}},
+		{name: "negative replay window", rules: models.RoomRules{ReplayWindowHours: -1}, wantErr: true},
+		{name: "replay window too long", rules: models.RoomRules{ReplayWindowHours: maxReplayWindowHours + 1}, wantErr: true},
+		{name: "negative max duration", rules: models.RoomRules{MaxDurationSeconds: -1}, wantErr: true},
+		{name: "negative pending limit", rules: models.RoomRules{MaxPendingPerUser: -1}, wantErr: true},
+		{name: "too many banned artists", rules: models.RoomRules{BannedArtists: tooMany}, wantErr: true},
+		{name: "too many banned tracks", rules: models.RoomRules{BannedTracks: tooMany}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRules(tt.rules)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validateRules() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSetting) {
+				t.Errorf("validateRules() error = %v, want ErrInvalidSetting", err)
+			}
+		})
+	}
+}
+
+func TestCheckTrackRules(t *testing.T) {
+	track := func(edit func(*models.QueueItem)) *models.QueueItem {
+		it := &models.QueueItem{
+			TrackID:    "track",
+			DurationMs: 200000,
+			Artists:    []models.TrackArtist{{ID: "artist1", Name: "First"}, {ID: "artist2", Name: "Second"}},
+		}
+		if edit != nil {
+			edit(it)
+		}
+		return it
+	}
+
+	tests := []struct {
+		name     string
+		rules    models.RoomRules
+		item     *models.QueueItem
+		wantRule string
+	}{
+		{name: "no rules", item: track(nil)},
+		{name: "banned track", rules: models.RoomRules{BannedTracks: []string{"track"}}, item: track(nil), wantRule: RuleBannedTrack},
+		{name: "explicit blocked", rules: models.RoomRules{BlockExplicit: true}, item: track(func(it *models.QueueItem) { it.Explicit = true }), wantRule: RuleExplicit},
+		{name: "explicit allowed", item: track(func(it *models.QueueItem) { it.Explicit = true })},
+		{name: "too long", rules: models.RoomRules{MaxDurationSeconds: 180}, item: track(nil), wantRule: RuleTooLong},
+		{name: "exactly the limit", rules: models.RoomRules{MaxDurationSeconds: 200}, item: track(nil)},
+		{name: "any banned artist", rules: models.RoomRules{BannedArtists: []string{"artist2"}}, item: track(nil), wantRule: RuleBannedArtist},
+		{name: "other artist banned", rules: models.RoomRules{BannedArtists: []string{"artist3"}}, item: track(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTrackRules(tt.rules, tt.item)
+			var violation *RuleViolation
+			switch {
+			case tt.wantRule == "" && err != nil:
+				t.Fatalf("checkTrackRules() error = %v, want none", err)
+			case tt.wantRule != "" && !errors.As(err, &violation):
+				t.Fatalf("checkTrackRules() error = %v, want a %s violation", err, tt.wantRule)
+			case tt.wantRule != "" && violation.Rule != tt.wantRule:
+				t.Errorf("checkTrackRules() rule = %s, want %s", violation.Rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+// fakeQueue is an in-memory queue whose mutex stands in for the room row
+// lock AddToQueue holds in MySQL.
+type fakeQueue struct {
+	mu    sync.Mutex
+	items []*models.QueueItem
+}
+
+func (f *fakeQueue) AddToQueue(item *models.QueueItem, check func(q database.QueueReader) error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := check(f); err != nil {
+		return err
+	}
+	f.items = append(f.items, item)
+	return nil
+}
+
+func (f *fakeQueue) HasPendingTrack(roomID, trackID string) (bool, error) {
+	for _, it := range f.items {
+		if it.RoomID.String() == roomID && it.TrackID == trackID && !it.Played {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeQueue) PlayedSince(roomID, trackID string, since time.Time) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeQueue) CountPendingByUser(roomID, userID string) (int, error) {
+	count := 0
+	for _, it := range f.items {
+		if it.RoomID.String() == roomID && it.UserID.String() == userID && !it.Played {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func TestAddCheckedConcurrentAdds(t *testing.T) {
+	const adders = 20
+
+	tests := []struct {
+		name      string
+		rules     models.RoomRules
+		item      func(i int) *models.QueueItem
+		wantAdded int
+		wantRule  string
+	}{
+		{
+			name:      "same track",
+			item:      func(i int) *models.QueueItem { return &models.QueueItem{TrackID: "track", UserID: uuid.New()} },
+			wantAdded: 1,
+			wantRule:  RuleDuplicate,
+		},
+		{
+			name:  "pending limit",
+			rules: models.RoomRules{MaxPendingPerUser: 3},
+			item: func(i int) *models.QueueItem {
+				return &models.QueueItem{TrackID: fmt.Sprint("track", i), UserID: alice}
+			},
+			wantAdded: 3,
+			wantRule:  RulePendingLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			room := &models.Room{ID: uuid.New(), Rules: tt.rules}
+			queue := &fakeQueue{}
+
+			start := make(chan struct{})
+			errs := make(chan error, adders)
+			var wg sync.WaitGroup
+			for i := 0; i < adders; i++ {
+				item := tt.item(i)
+				item.ID = uuid.New()
+				item.RoomID = room.ID
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					errs <- addChecked(queue, room, item)
+				}()
+			}
+			close(start)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				var violation *RuleViolation
+				if err != nil && (!errors.As(err, &violation) || violation.Rule != tt.wantRule) {
+					t.Errorf("addChecked() error = %v, want nil or a %s violation", err, tt.wantRule)
+				}
+			}
+			if len(queue.items) != tt.wantAdded {
+				t.Errorf("added %d items, want %d", len(queue.items), tt.wantAdded)
+			}
+		})
+	}
+}
@@ -2,54 +2,92 @@ package room
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
 
+	"github.com/music-queue-system/internal/spotify"
 	"github.com/music-queue-system/pkg/database"
 	"github.com/music-queue-system/pkg/events"
 	"github.com/music-queue-system/pkg/models"
 )
 
 const (
-	roomKeyPrefix    = "room:"
-	roomQueuePrefix = "queue:"
-	codeLength      = 6
+	roomKeyPrefix = "room:"
+	codeLength    = 6
 )
 
 type Service struct {
 	db      *database.MySQLDB
 	redis   *redis.Client
 	events  *events.KafkaClient
+	spotify *spotify.Client
 }
 
-func NewService(db *database.MySQLDB, redis *redis.Client, events *events.KafkaClient) *Service {
+func NewService(db *database.MySQLDB, redis *redis.Client, events *events.KafkaClient, spotifyClient *spotify.Client) *Service {
 	return &Service{
-		db:     db,
-		redis:  redis,
-		events: events,
+		db:      db,
+		redis:   redis,
+		events:  events,
+		spotify: spotifyClient,
 	}
 }
 
-func (s *Service) CreateRoom(ctx context.Context, hostID string, name string) (*models.Room, error) {
+// maxCodeAttempts bounds the retries when a generated room code collides
+// with an existing one.
+const maxCodeAttempts = 5
+
+func (s *Service) CreateRoom(ctx context.Context, hostID, name string, private bool, passcode string) (*models.Room, error) {
+	host, err := uuid.Parse(hostID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid user ID", ErrForbidden)
+	}
+
 	room := &models.Room{
-		ID:        uuid.New(),
-		Code:      generateRoomCode(),
-		HostID:    uuid.MustParse(hostID),
-		Name:      name,
-		Active:    true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:           uuid.New(),
+		HostID:       host,
+		Name:         name,
+		Active:       true,
+		Private:      private,
+		LastActiveAt: time.Now(),
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if passcode != "" {
+		hash, err := hashPasscode(passcode)
+		if err != nil {
+			return nil, err
+		}
+		room.PasscodeHash = hash
+	}
+
+	// Store room in MySQL, retrying with a fresh code on collision
+	for attempt := 1; ; attempt++ {
+		code, err := generateRoomCode()
+		if err != nil {
+			return nil, err
+		}
+		room.Code = code
+		room.LiveCode = &code
+
+		err = s.db.CreateRoom(room)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) || attempt == maxCodeAttempts {
+			return nil, fmt.Errorf("failed to create room: %w", err)
+		}
 	}
 
-	// Store room in MySQL
-	if err := s.db.CreateRoom(room); err != nil {
-		return nil, fmt.Errorf("failed to create room: %w", err)
+	if _, err := s.addMember(ctx, room, room.HostID); err != nil {
+		log.Printf("Warning: failed to add host as member: %v", err)
 	}
 
 	// Cache room in Redis
@@ -79,6 +117,9 @@ func (s *Service) GetRoom(ctx context.Context, roomID string) (*models.Room, err
 
 	// Fallback to database
 	room, err := s.db.GetRoomByID(roomID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoomNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get room: %w", err)
 	}
@@ -90,9 +131,24 @@ func (s *Service) GetRoom(ctx context.Context, roomID string) (*models.Room, err
 	return room, nil
 }
 
+// ViewRoom returns the room if userID may see it.
+func (s *Service) ViewRoom(ctx context.Context, roomID, userID string) (*models.Room, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
 func (s *Service) GetRoomByCode(ctx context.Context, code string) (*models.Room, error) {
 	// Try getting from database
 	room, err := s.db.GetRoomByCode(code)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoomNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get room: %w", err)
 	}
@@ -105,82 +161,230 @@ func (s *Service) GetRoomByCode(ctx context.Context, code string) (*models.Room,
 	return room, nil
 }
 
-func (s *Service) AddToQueue(ctx context.Context, roomID string, item *models.QueueItem) error {
-	// Get current queue from database
-	queue, err := s.db.GetQueue(roomID)
+// AddToQueue queues a song by its Spotify track ID, with the rest of its
+// metadata taken from Spotify, after checking it against the room's rules.
+// A rejected song is reported as a *RuleViolation.
+func (s *Service) AddToQueue(ctx context.Context, roomID, userID, trackID string) (*models.QueueItem, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermAddSong); err != nil {
+		return nil, err
+	}
+	user, err := uuid.Parse(userID)
 	if err != nil {
-		return fmt.Errorf("failed to get queue: %w", err)
+		return nil, fmt.Errorf("%w: invalid user ID", ErrForbidden)
+	}
+
+	item := &models.QueueItem{
+		RoomID:  room.ID,
+		UserID:  user,
+		TrackID: trackID,
+	}
+	if err := s.resolveTrack(ctx, item); err != nil {
+		return nil, err
 	}
-	
-	item.Position = len(queue)
+
 	item.ID = uuid.New()
 	item.CreatedAt = time.Now()
 	item.UpdatedAt = time.Now()
 
 	// Add to database
-	if err := s.db.AddToQueue(item); err != nil {
-		return fmt.Errorf("failed to add to queue: %w", err)
+	if err := addChecked(s.db, room, item); err != nil {
+		var violation *RuleViolation
+		if errors.As(err, &violation) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to add to queue: %w", err)
 	}
+	s.touch(ctx, roomID)
 
 	// Publish event
 	payload := events.SongAddedPayload{
-		TrackID:   item.TrackID,
-		TrackName: item.TrackName,
-		Artist:    item.Artist,
+		QueueItemID: item.ID.String(),
+		TrackID:     item.TrackID,
+		TrackName:   item.TrackName,
+		Artist:      item.Artist,
 	}
 
-	if err := s.events.PublishEvent(ctx, "song-additions", payload); err != nil {
-		return fmt.Errorf("failed to publish event: %w", err)
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeSongAdded, roomID, userID, payload); err != nil {
+		return nil, fmt.Errorf("failed to publish event: %w", err)
 	}
 
-	return nil
+	return item, nil
 }
 
+// GetQueue returns the room's upcoming songs in the order they will play:
+// pinned items at their positions and the rest as decided by the room's
+// ranking strategy. The song playing now is not included.
 func (s *Service) GetQueue(ctx context.Context, roomID string) ([]*models.QueueItem, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, _, err := s.rankedQueue(ctx, room)
+	return queue, err
+}
+
+// rankedQueue is GetQueue for a loaded room, also returning the vote
+// tallies it ranked by.
+func (s *Service) rankedQueue(ctx context.Context, room *models.Room) ([]*models.QueueItem, map[uuid.UUID]models.VoteTally, error) {
+	roomID := room.ID.String()
+
 	// Get queue from database
 	queue, err := s.db.GetQueue(roomID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get queue: %w", err)
+		return nil, nil, fmt.Errorf("failed to get queue: %w", err)
+	}
+
+	playback, err := s.loadPlayback(ctx, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if playback.Status == models.PlaybackPlaying || playback.Status == models.PlaybackPaused {
+		queue = withoutItem(queue, playback.QueueItemID)
+	}
+
+	tallyList, err := s.db.GetVoteTallies(roomID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get vote tallies: %w", err)
+	}
+	tallies := make(map[uuid.UUID]models.VoteTally, len(tallyList))
+	for _, t := range tallyList {
+		tallies[t.QueueItemID] = t
 	}
 
-	return queue, nil
+	state := &QueueState{
+		Room:    room,
+		Items:   queue,
+		Tallies: tallies,
+		Now:     time.Now(),
+	}
+	if room.Ranking == models.RankingRotation {
+		if state.Credits, err = s.rotationCredits(ctx, roomID); err != nil {
+			return nil, nil, err
+		}
+		if state.Roles, err = s.roomRoles(ctx, room, queue); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return applyPins(rankerFor(room).Rank(state)), tallies, nil
 }
 
-func (s *Service) Vote(ctx context.Context, roomID string, trackID string, userID string, voteValue int) error {
+// Vote records the user's up or down vote on an unplayed item of the
+// room, replacing any earlier vote of theirs on it. weight is 1 for a
+// plain vote; in credit rooms up-votes can weigh more and are paid for
+// from the user's credits, with the cost of a replaced vote refunded.
+func (s *Service) Vote(ctx context.Context, roomID, userID, itemID string, voteValue, weight int) error {
+	if voteValue != 1 && voteValue != -1 {
+		return ErrInvalidVote
+	}
+	if weight == 0 {
+		weight = 1
+	}
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermVote); err != nil {
+		return err
+	}
+	if err := validateVoteWeight(room, voteValue, weight); err != nil {
+		return err
+	}
+	user, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("%w: invalid user ID", ErrForbidden)
+	}
+	item, err := s.queueItem(ctx, roomID, itemID)
+	if err != nil {
+		return err
+	}
+
 	vote := &models.Vote{
 		ID:          uuid.New(),
-		QueueItemID: uuid.MustParse(trackID),
-		UserID:      uuid.MustParse(userID),
+		QueueItemID: item.ID,
+		UserID:      user,
 		Value:       voteValue,
+		Weight:      weight,
 		CreatedAt:   time.Now(),
 	}
 
-	// Store vote in database
-	if err := s.db.CreateOrUpdateVote(vote); err != nil {
-		return fmt.Errorf("failed to store vote: %w", err)
+	// Pay for the vote up front, less what the vote it replaces cost
+	existing, err := s.db.GetVote(itemID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get vote: %w", err)
+	}
+	cost := voteCost(room, vote) - voteCost(room, existing)
+	if err := s.chargeCredits(ctx, room, userID, cost, false); err != nil {
+		return err
 	}
 
-	// Get updated vote count and publish event
-	total, err := s.db.GetVotesForItem(trackID)
+	// Store vote and update the item's total
+	total, previous, err := s.db.CreateOrUpdateVote(vote)
 	if err != nil {
-		return fmt.Errorf("failed to get total votes: %w", err)
+		if refundErr := s.chargeCredits(ctx, room, userID, -cost, true); refundErr != nil {
+			log.Printf("Failed to refund vote credits: %v", refundErr)
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrQueueItemNotFound
+		}
+		return fmt.Errorf("failed to store vote: %w", err)
 	}
+	// A concurrent vote of the user's may have replaced the one priced in
+	if correction := voteCost(room, existing) - voteCost(room, previous); correction != 0 {
+		if err := s.chargeCredits(ctx, room, userID, correction, true); err != nil {
+			log.Printf("Failed to correct vote credits: %v", err)
+		}
+	}
+	s.touch(ctx, roomID)
 
 	// Publish vote event with total
-	s.events.PublishVoteUpdate(ctx, roomID, trackID, total)
+	return s.publishVote(ctx, room, userID, events.SongVotedPayload{
+		QueueItemID: itemID,
+		TrackID:     item.TrackID,
+		Value:       voteValue,
+		Weight:      weight,
+		TotalVotes:  total,
+	})
+}
 
-	// Publish vote event
-	payload := events.SongVotedPayload{
-		TrackID: trackID,
-		UserID:  userID,
-		Value:   voteValue,
+// ReconcileVotes rebuilds the room's vote totals from the individual votes.
+func (s *Service) ReconcileVotes(ctx context.Context, roomID, userID string) (int64, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermManageSettings); err != nil {
+		return 0, err
 	}
 
-	if err := s.events.PublishEvent(ctx, "song-votes", payload); err != nil {
-		return fmt.Errorf("failed to publish event: %w", err)
+	fixed, err := s.db.ReconcileVoteCounts(roomID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reconcile votes: %w", err)
 	}
+	return fixed, nil
+}
 
-	return nil
+// RunVoteReconciler periodically repairs vote totals across all rooms.
+func (s *Service) RunVoteReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fixed, err := s.db.ReconcileVoteCounts("")
+			if err != nil {
+				log.Printf("Failed to reconcile vote totals: %v", err)
+				continue
+			}
+			if fixed > 0 {
+				log.Printf("Reconciled vote totals for %d queue items", fixed)
+			}
+		}
+	}
 }
 
 func (s *Service) calculateTotalVotes(ctx context.Context, voteKey string) (int, error) {
@@ -201,21 +405,48 @@ func (s *Service) calculateTotalVotes(ctx context.Context, voteKey string) (int,
 	return total, nil
 }
 
-func generateRoomCode() string {
+func generateRoomCode() (string, error) {
 	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	code := make([]byte, codeLength)
-	for i := range code {
-		code[i] = charset[rand.Intn(len(charset))]
+	// Bytes at or above limit are rejected so every character is equally likely
+	const limit = 256 - 256%len(charset)
+
+	code := make([]byte, 0, codeLength)
+	buf := make([]byte, codeLength)
+	for len(code) < codeLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", fmt.Errorf("failed to generate room code: %w", err)
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(code) < codeLength {
+				code = append(code, charset[int(b)%len(charset)])
+			}
+		}
 	}
-	return string(code)
+	return string(code), nil
 }
 
 func (s *Service) GetNextSong(ctx context.Context, roomID string) (*models.QueueItem, error) {
-	// Get next song directly from database (ordered by votes)
-	nextSong, err := s.db.GetNextSong(roomID)
+	queue, err := s.GetQueue(ctx, roomID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get next song: %w", err)
 	}
 
-	return nextSong, nil
+	if len(queue) == 0 {
+		return nil, nil
+	}
+	return queue[0], nil
+}
+
+// cacheRoom refreshes the cached copy of room after it changed.
+func (s *Service) cacheRoom(ctx context.Context, room *models.Room) {
+	roomJSON, err := json.Marshal(room)
+	if err != nil {
+		log.Printf("Warning: failed to marshal room: %v", err)
+		return
+	}
+
+	key := fmt.Sprintf("%s%s", roomKeyPrefix, room.ID)
+	if err := s.redis.Set(ctx, key, roomJSON, 24*time.Hour).Err(); err != nil {
+		log.Printf("Warning: failed to cache room: %v", err)
+	}
 }
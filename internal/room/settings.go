@@ -0,0 +1,159 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/music-queue-system/pkg/models"
+)
+
+// Settings is a partial update of a room's host-controlled settings. Nil
+// fields are left unchanged.
+type Settings struct {
+	Ranking            *string          `json:"ranking"`
+	RotationWeights    *map[string]int  `json:"rotation_weights"`
+	DefaultRole        *models.RoomRole `json:"default_role"`
+	Private            *bool            `json:"private"`
+	ShowVoters         *bool            `json:"show_voters"`
+	VoteMode           *string          `json:"vote_mode"`
+	VoteCreditsPerHour *int             `json:"vote_credits_per_hour"`
+	SkipVoteMode       *string          `json:"skip_vote_mode"`
+	SkipVoteThreshold  *int             `json:"skip_vote_threshold"`
+	// Rules replaces the room's content rules as a whole.
+	Rules *models.RoomRules `json:"rules"`
+	// Passcode sets the private room passcode; an empty string removes it,
+	// making the room invite-only.
+	Passcode *string `json:"passcode"`
+}
+
+const maxRotationWeight = 10
+
+func (s *Service) UpdateSettings(ctx context.Context, roomID, userID string, settings Settings) (*models.Room, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermManageSettings); err != nil {
+		return nil, err
+	}
+
+	// Read from the database, not the cache, so settings validated
+	// against each other see their current values
+	room, err := s.db.GetRoomByID(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	// Only the changed columns are written, so a room closed meanwhile
+	// stays closed
+	var columns []string
+
+	if settings.Ranking != nil {
+		if _, ok := rankers[*settings.Ranking]; !ok {
+			return nil, fmt.Errorf("%w: unknown ranking %q", ErrInvalidSetting, *settings.Ranking)
+		}
+		room.Ranking = *settings.Ranking
+		columns = append(columns, "ranking")
+	}
+
+	if settings.RotationWeights != nil {
+		for key, weight := range *settings.RotationWeights {
+			if _, err := uuid.Parse(key); err != nil && rolePermissions[models.RoomRole(key)] == nil {
+				return nil, fmt.Errorf("%w: rotation weight key %q is not a user ID or role", ErrInvalidSetting, key)
+			}
+			if weight < 1 || weight > maxRotationWeight {
+				return nil, fmt.Errorf("%w: rotation weights must be between 1 and %d", ErrInvalidSetting, maxRotationWeight)
+			}
+		}
+		room.RotationWeights = *settings.RotationWeights
+		columns = append(columns, "rotation_weights")
+	}
+
+	if settings.DefaultRole != nil {
+		if *settings.DefaultRole != models.RoleDJ && *settings.DefaultRole != models.RoleListener {
+			return nil, fmt.Errorf("%w: default role must be %s or %s", ErrInvalidSetting, models.RoleDJ, models.RoleListener)
+		}
+		room.DefaultRole = *settings.DefaultRole
+		columns = append(columns, "default_role")
+	}
+
+	if settings.ShowVoters != nil {
+		room.ShowVoters = *settings.ShowVoters
+		columns = append(columns, "show_voters")
+	}
+
+	if settings.VoteMode != nil {
+		if *settings.VoteMode != models.VoteModeUnlimited && *settings.VoteMode != models.VoteModeCredits {
+			return nil, fmt.Errorf("%w: vote mode must be %s or %s", ErrInvalidSetting, models.VoteModeUnlimited, models.VoteModeCredits)
+		}
+		room.VoteMode = *settings.VoteMode
+		columns = append(columns, "vote_mode")
+	}
+	if settings.VoteCreditsPerHour != nil {
+		if err := validateVoteCredits(*settings.VoteCreditsPerHour); err != nil {
+			return nil, err
+		}
+		room.VoteCreditsPerHour = *settings.VoteCreditsPerHour
+		columns = append(columns, "vote_credits_per_hour")
+	}
+
+	if settings.SkipVoteMode != nil {
+		room.SkipVoteMode = *settings.SkipVoteMode
+		columns = append(columns, "skip_vote_mode")
+	}
+	if settings.SkipVoteThreshold != nil {
+		room.SkipVoteThreshold = *settings.SkipVoteThreshold
+		columns = append(columns, "skip_vote_threshold")
+	}
+	if settings.SkipVoteMode != nil || settings.SkipVoteThreshold != nil {
+		if err := validateSkipThreshold(room.SkipVoteMode, room.SkipVoteThreshold); err != nil {
+			return nil, err
+		}
+	}
+
+	if settings.Rules != nil {
+		if err := validateRules(*settings.Rules); err != nil {
+			return nil, err
+		}
+		room.Rules = *settings.Rules
+		columns = append(columns, "rules")
+	}
+
+	if settings.Private != nil {
+		room.Private = *settings.Private
+		columns = append(columns, "private")
+	}
+	if settings.Passcode != nil {
+		room.PasscodeHash = ""
+		if *settings.Passcode != "" {
+			if room.PasscodeHash, err = hashPasscode(*settings.Passcode); err != nil {
+				return nil, err
+			}
+		}
+		columns = append(columns, "passcode_hash")
+	}
+
+	if len(columns) == 0 {
+		return room, nil
+	}
+
+	room.UpdatedAt = time.Now()
+	columns = append(columns, "updated_at")
+	if err := s.db.UpdateRoomColumns(room, columns...); err != nil {
+		return nil, fmt.Errorf("failed to update room: %w", err)
+	}
+
+	// Reload to pick up anything written alongside this update
+	room, err = s.db.GetRoomByID(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+	s.cacheRoom(ctx, room)
+
+	// A room that stops rotating starts the rotation afresh if it goes
+	// back to it
+	if settings.Ranking != nil && room.Ranking != models.RankingRotation {
+		s.redis.Del(ctx, rotationKeyPrefix+roomID)
+	}
+
+	return room, nil
+}
@@ -0,0 +1,209 @@
+package room
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
+)
+
+const (
+	skipVotesKeyPrefix = "skipvotes:"
+	// skipVotesTTL only has to outlive the song being voted on.
+	skipVotesTTL = 24 * time.Hour
+
+	maxSkipVoteCount = 1000
+)
+
+// SkipProgress is how close the playing song is to being skipped.
+type SkipProgress struct {
+	QueueItemID string `json:"queue_item_id"`
+	Votes       int    `json:"votes"`
+	Needed      int    `json:"needed"`
+	Voted       bool   `json:"voted"`
+	Skipped     bool   `json:"skipped"`
+}
+
+// VoteSkip records the user's vote to skip the playing song and skips it
+// once the room's threshold is reached.
+func (s *Service) VoteSkip(ctx context.Context, roomID, userID string) (*SkipProgress, error) {
+	room, state, err := s.skipVoteTarget(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key := skipVotesKey(roomID, state)
+	pipe := s.redis.TxPipeline()
+	pipe.SAdd(ctx, key, userID)
+	pipe.Expire(ctx, key, skipVotesTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record skip vote: %w", err)
+	}
+
+	progress, err := s.skipProgress(ctx, room, state, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if progress.Votes >= progress.Needed {
+		// AdvancePlayback is a no-op if the song already changed, so
+		// simultaneous deciding votes skip once
+		if _, err := s.AdvancePlayback(ctx, roomID, state.QueueItemID, true); err != nil {
+			return nil, err
+		}
+		progress.Skipped = true
+		s.redis.Del(ctx, key)
+	}
+
+	s.publishSkipProgress(ctx, roomID, userID, progress)
+	return progress, nil
+}
+
+// WithdrawSkipVote takes back the user's vote to skip the playing song.
+func (s *Service) WithdrawSkipVote(ctx context.Context, roomID, userID string) (*SkipProgress, error) {
+	room, state, err := s.skipVoteTarget(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := s.redis.SRem(ctx, skipVotesKey(roomID, state), userID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to withdraw skip vote: %w", err)
+	}
+	if removed == 0 {
+		return nil, ErrNoSkipVote
+	}
+
+	progress, err := s.skipProgress(ctx, room, state, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishSkipProgress(ctx, roomID, userID, progress)
+	return progress, nil
+}
+
+// SkipVotes returns the skip vote progress on the playing song.
+func (s *Service) SkipVotes(ctx context.Context, roomID, userID string) (*SkipProgress, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	state, err := s.loadPlayback(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if state.Status != models.PlaybackPlaying && state.Status != models.PlaybackPaused {
+		return nil, ErrNothingPlaying
+	}
+
+	return s.skipProgress(ctx, room, state, userID)
+}
+
+// skipVoteTarget checks the user may vote in the room and returns the
+// song being played.
+func (s *Service) skipVoteTarget(ctx context.Context, roomID, userID string) (*models.Room, *models.PlaybackState, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermVote); err != nil {
+		return nil, nil, err
+	}
+
+	state, err := s.loadPlayback(ctx, roomID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if state.Status != models.PlaybackPlaying && state.Status != models.PlaybackPaused {
+		return nil, nil, ErrNothingPlaying
+	}
+	return room, state, nil
+}
+
+func (s *Service) skipProgress(ctx context.Context, room *models.Room, state *models.PlaybackState, userID string) (*SkipProgress, error) {
+	key := skipVotesKey(room.ID.String(), state)
+	pipe := s.redis.Pipeline()
+	count := pipe.SCard(ctx, key)
+	voted := pipe.SIsMember(ctx, key, userID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to count skip votes: %w", err)
+	}
+
+	needed, err := s.skipVotesNeeded(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SkipProgress{
+		QueueItemID: state.QueueItemID.String(),
+		Votes:       int(count.Val()),
+		Needed:      needed,
+		Voted:       voted.Val(),
+	}, nil
+}
+
+// skipVotesNeeded applies the room's threshold. Percentages are of the
+// users connected right now on any instance, rounded up, and never less
+// than one vote.
+func (s *Service) skipVotesNeeded(ctx context.Context, room *models.Room) (int, error) {
+	if room.SkipVoteMode == models.SkipVoteCount && room.SkipVoteThreshold > 0 {
+		return room.SkipVoteThreshold, nil
+	}
+
+	percent := room.SkipVoteThreshold
+	if percent <= 0 || percent > 100 {
+		percent = 50
+	}
+	connected, err := s.connectedUsers(ctx, room.ID.String())
+	if err != nil {
+		return 0, err
+	}
+
+	needed := (connected*percent + 99) / 100
+	if needed < 1 {
+		needed = 1
+	}
+	return needed, nil
+}
+
+func (s *Service) publishSkipProgress(ctx context.Context, roomID, userID string, progress *SkipProgress) {
+	payload := events.SkipVotePayload{
+		QueueItemID: progress.QueueItemID,
+		Votes:       progress.Votes,
+		Needed:      progress.Needed,
+		Skipped:     progress.Skipped,
+	}
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeSkipVote, roomID, userID, payload); err != nil {
+		log.Printf("Failed to publish skip vote event: %v", err)
+	}
+}
+
+// skipVotesKey is per queue item, so votes never carry over to the next
+// song.
+func skipVotesKey(roomID string, state *models.PlaybackState) string {
+	return skipVotesKeyPrefix + roomID + ":" + state.QueueItemID.String()
+}
+
+func validateSkipThreshold(mode string, threshold int) error {
+	switch mode {
+	case models.SkipVotePercent:
+		if threshold < 1 || threshold > 100 {
+			return fmt.Errorf("%w: skip vote percentage must be between 1 and 100", ErrInvalidSetting)
+		}
+	case models.SkipVoteCount:
+		if threshold < 1 || threshold > maxSkipVoteCount {
+			return fmt.Errorf("%w: skip vote count must be between 1 and %d", ErrInvalidSetting, maxSkipVoteCount)
+		}
+	default:
+		return fmt.Errorf("%w: skip vote mode must be %s or %s", ErrInvalidSetting, models.SkipVotePercent, models.SkipVoteCount)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package room
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/music-queue-system/internal/spotify"
+	"github.com/music-queue-system/pkg/models"
+)
+
+const (
+	trackKeyPrefix = "track:"
+	// Track metadata hardly ever changes, so it is cached for long.
+	trackCacheTTL = 7 * 24 * time.Hour
+
+	spotifyIDLength = 22
+)
+
+// lookupTrack returns the track's Spotify metadata, cached in Redis so
+// repeated adds of a song do not hit the API. Tracks Spotify does not know
+// are reported as ErrUnknownTrack.
+func (s *Service) lookupTrack(ctx context.Context, trackID string) (*spotify.Track, error) {
+	key := trackKeyPrefix + trackID
+	if cached, err := s.redis.Get(ctx, key).Bytes(); err == nil {
+		var track spotify.Track
+		if err := json.Unmarshal(cached, &track); err == nil {
+			return &track, nil
+		}
+	}
+
+	token, err := s.spotify.AppToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTrackLookup, err)
+	}
+	track, err := s.spotify.GetTrack(ctx, token, trackID)
+	if errors.Is(err, spotify.ErrNotFound) || isBadTrackID(err) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTrack, trackID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTrackLookup, err)
+	}
+
+	if trackJSON, err := json.Marshal(track); err == nil {
+		if err := s.redis.Set(ctx, key, trackJSON, trackCacheTTL).Err(); err != nil {
+			log.Printf("Warning: failed to cache track: %v", err)
+		}
+	}
+	return track, nil
+}
+
+func isBadTrackID(err error) bool {
+	var apiErr *spotify.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest
+}
+
+// validTrackID reports whether id looks like a Spotify ID: 22 base62
+// characters. Anything else is rejected before it reaches the API or a
+// cache key.
+func validTrackID(id string) bool {
+	if len(id) != spotifyIDLength {
+		return false
+	}
+	for _, r := range id {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveTrack fills item's track metadata from Spotify, replacing
+// anything the client sent.
+func (s *Service) resolveTrack(ctx context.Context, item *models.QueueItem) error {
+	if !validTrackID(item.TrackID) {
+		return fmt.Errorf("%w: invalid track ID %q", ErrUnknownTrack, item.TrackID)
+	}
+
+	track, err := s.lookupTrack(ctx, item.TrackID)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(track.Artists))
+	item.Artists = make([]models.TrackArtist, len(track.Artists))
+	for i, artist := range track.Artists {
+		names[i] = artist.Name
+		item.Artists[i] = models.TrackArtist{ID: artist.ID, Name: artist.Name}
+	}
+
+	item.TrackID = track.ID
+	item.TrackName = track.Name
+	item.Artist = strings.Join(names, ", ")
+	item.Album = track.Album.Name
+	item.ArtworkURL = ""
+	if len(track.Album.Images) > 0 {
+		// Spotify lists album images largest first
+		item.ArtworkURL = track.Album.Images[0].URL
+	}
+	item.DurationMs = track.Duration
+	item.Explicit = track.Explicit
+	item.ISRC = track.ISRC()
+	return nil
+}
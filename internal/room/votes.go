@@ -0,0 +1,150 @@
+package room
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+
+	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
+)
+
+// QueueEntry is a queue item as shown to one user: its up and down vote
+// counts and the user's own vote, 1, -1 or 0 for none.
+type QueueEntry struct {
+	*models.QueueItem
+	UpVotes   int `json:"up_votes"`
+	DownVotes int `json:"down_votes"`
+	MyVote    int `json:"my_vote"`
+}
+
+// QueueFor returns the room's queue in play order with vote breakdowns
+// and userID's votes.
+func (s *Service) QueueFor(ctx context.Context, roomID, userID string) ([]*QueueEntry, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	queue, tallies, err := s.rankedQueue(ctx, room)
+	if err != nil {
+		return nil, err
+	}
+
+	myVotes, err := s.db.GetUserVotes(roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get votes: %w", err)
+	}
+	mine := make(map[string]int, len(myVotes))
+	for _, v := range myVotes {
+		mine[v.QueueItemID.String()] = v.Value
+	}
+
+	entries := make([]*QueueEntry, len(queue))
+	for i, item := range queue {
+		tally := tallies[item.ID]
+		entries[i] = &QueueEntry{
+			QueueItem: item,
+			UpVotes:   tally.Up,
+			DownVotes: tally.Down,
+			MyVote:    mine[item.ID.String()],
+		}
+	}
+	return entries, nil
+}
+
+// RetractVote removes the user's vote on a queue item.
+func (s *Service) RetractVote(ctx context.Context, roomID, userID, itemID string) error {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermVote); err != nil {
+		return err
+	}
+	item, err := s.queueItem(ctx, roomID, itemID)
+	if err != nil {
+		return err
+	}
+
+	total, deleted, err := s.db.DeleteVote(itemID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrQueueItemNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retract vote: %w", err)
+	}
+	if deleted == nil {
+		return ErrNoVote
+	}
+	// DeleteVote only removes votes on songs that have not been played,
+	// so a vote on a song that is still in the queue gives back its cost
+	if err := s.chargeCredits(ctx, room, userID, -voteCost(room, deleted), true); err != nil {
+		log.Printf("Failed to refund vote credits: %v", err)
+	}
+	s.touch(ctx, roomID)
+
+	return s.publishVote(ctx, room, userID, events.SongVotedPayload{
+		QueueItemID: itemID,
+		TrackID:     item.TrackID,
+		Value:       0,
+		TotalVotes:  total,
+	})
+}
+
+// publishVote announces a vote to the room. Rooms that keep votes
+// anonymous are not told who cast it.
+func (s *Service) publishVote(ctx context.Context, room *models.Room, userID string, payload events.SongVotedPayload) error {
+	if !room.ShowVoters {
+		userID = ""
+	}
+	payload.UserID = userID
+
+	if err := s.events.PublishRoomEvent(ctx, events.EventTypeSongVoted, room.ID.String(), userID, payload); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// MyVotes returns the user's votes on the room's upcoming songs.
+func (s *Service) MyVotes(ctx context.Context, roomID, userID string) ([]*models.Vote, error) {
+	if err := s.Authorize(ctx, roomID, userID, PermView); err != nil {
+		return nil, err
+	}
+
+	votes, err := s.db.GetUserVotes(roomID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get votes: %w", err)
+	}
+	return votes, nil
+}
+
+// Voters lists who voted on a queue item. Rooms that keep votes anonymous
+// return ErrVotersHidden.
+func (s *Service) Voters(ctx context.Context, roomID, userID, itemID string) ([]*models.Voter, error) {
+	room, err := s.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.authorize(ctx, room, userID, PermView); err != nil {
+		return nil, err
+	}
+	if !room.ShowVoters {
+		return nil, ErrVotersHidden
+	}
+	if _, err := s.queueItem(ctx, roomID, itemID); err != nil {
+		return nil, err
+	}
+
+	voters, err := s.db.GetVoters(itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get voters: %w", err)
+	}
+	return voters, nil
+}
@@ -0,0 +1,70 @@
+package search
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/music-queue-system/internal/spotify"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(spotifyClient *spotify.Client, tokens TokenSource, redis *redis.Client) *Handler {
+	return &Handler{service: NewService(spotifyClient, tokens, redis)}
+}
+
+func (h *Handler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/search", h.search)
+}
+
+// search handles GET /search?q=&type=track,album&artist=&year=&genre=&limit=&offset=
+func (h *Handler) search(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a number"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a number"})
+		return
+	}
+
+	var types []string
+	if t := c.Query("type"); t != "" {
+		types = strings.Split(t, ",")
+	}
+
+	q := Query{
+		Text:   c.Query("q"),
+		Types:  types,
+		Artist: c.Query("artist"),
+		Year:   c.Query("year"),
+		Genre:  c.Query("genre"),
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	result, err := h.service.Search(c.Request.Context(), c.GetString("user_id"), q)
+	if err != nil {
+		if errors.Is(err, ErrInvalidQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, ErrNoToken) || errors.Is(err, spotify.ErrUnauthorized) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,176 @@
+package search
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/music-queue-system/internal/spotify"
+)
+
+const (
+	searchCachePrefix = "search:"
+	searchCacheTTL    = 10 * time.Minute
+	defaultLimit      = 20
+	maxLimit          = 50
+	// Spotify rejects offset+limit past this point.
+	maxOffset = 1000
+)
+
+var (
+	ErrInvalidQuery = errors.New("invalid search query")
+	ErrNoToken      = errors.New("no usable spotify token")
+
+	validTypes = map[string]bool{"track": true, "artist": true, "album": true, "playlist": true}
+	yearRe     = regexp.MustCompile(`^\d{4}(-\d{4})?$`)
+)
+
+// Query is a catalog search with optional field filters. Filters are
+// appended to the free text using Spotify's field syntax (artist:, year:,
+// genre:), so the same filters may also be typed directly into Text.
+type Query struct {
+	Text   string
+	Types  []string
+	Artist string
+	Year   string
+	Genre  string
+	Limit  int
+	Offset int
+}
+
+// normalize validates the query and brings it into a canonical form so that
+// equivalent searches share a cache entry.
+func (q *Query) normalize() error {
+	q.Text = strings.ToLower(strings.Join(strings.Fields(q.Text), " "))
+	q.Artist = strings.ToLower(strings.Join(strings.Fields(q.Artist), " "))
+	q.Genre = strings.ToLower(strings.Join(strings.Fields(q.Genre), " "))
+	q.Year = strings.TrimSpace(q.Year)
+
+	if q.Text == "" && q.Artist == "" && q.Genre == "" && q.Year == "" {
+		return fmt.Errorf("%w: q or a filter is required", ErrInvalidQuery)
+	}
+	if q.Year != "" && !yearRe.MatchString(q.Year) {
+		return fmt.Errorf("%w: year must be YYYY or YYYY-YYYY", ErrInvalidQuery)
+	}
+
+	if len(q.Types) == 0 {
+		q.Types = []string{"track"}
+	}
+	seen := make(map[string]bool)
+	types := make([]string, 0, len(q.Types))
+	for _, t := range q.Types {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if !validTypes[t] {
+			return fmt.Errorf("%w: unsupported type %q", ErrInvalidQuery, t)
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	sort.Strings(types)
+	q.Types = types
+
+	if q.Limit <= 0 {
+		q.Limit = defaultLimit
+	}
+	if q.Limit > maxLimit {
+		q.Limit = maxLimit
+	}
+	if q.Offset < 0 || q.Offset+q.Limit > maxOffset {
+		return fmt.Errorf("%w: offset must be between 0 and %d", ErrInvalidQuery, maxOffset-q.Limit)
+	}
+
+	return nil
+}
+
+// spotifyQuery renders the q parameter sent to Spotify.
+func (q *Query) spotifyQuery() string {
+	parts := make([]string, 0, 4)
+	if q.Text != "" {
+		parts = append(parts, q.Text)
+	}
+	if q.Artist != "" {
+		parts = append(parts, fmt.Sprintf("artist:%q", q.Artist))
+	}
+	if q.Year != "" {
+		parts = append(parts, "year:"+q.Year)
+	}
+	if q.Genre != "" {
+		parts = append(parts, fmt.Sprintf("genre:%q", q.Genre))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (q *Query) cacheKey() string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%d|%s", strings.Join(q.Types, ","), q.Offset, q.Limit, q.spotifyQuery())))
+	return searchCachePrefix + hex.EncodeToString(sum[:])
+}
+
+// TokenSource hands out Spotify access tokens for a user, refreshing them
+// as needed. It is implemented by *player.Service.
+type TokenSource interface {
+	AccessToken(ctx context.Context, userID string) (string, error)
+}
+
+type Service struct {
+	spotify *spotify.Client
+	tokens  TokenSource
+	redis   *redis.Client
+}
+
+func NewService(spotifyClient *spotify.Client, tokens TokenSource, redis *redis.Client) *Service {
+	return &Service{
+		spotify: spotifyClient,
+		tokens:  tokens,
+		redis:   redis,
+	}
+}
+
+// Search runs q against the catalog with the user's Spotify token. Results
+// are cached across users.
+func (s *Service) Search(ctx context.Context, userID string, q Query) (*spotify.SearchResponse, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	// Try cache first
+	key := q.cacheKey()
+	cached, err := s.redis.Get(ctx, key).Bytes()
+	if err == nil {
+		var result spotify.SearchResponse
+		if err := json.Unmarshal(cached, &result); err == nil {
+			return &result, nil
+		}
+	}
+
+	accessToken, err := s.tokens.AccessToken(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoToken, err)
+	}
+
+	result, err := s.spotify.Search(ctx, accessToken, q.spotifyQuery(), q.Types, q.Limit, q.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+
+	// Update cache
+	resultJSON, err := json.Marshal(result)
+	if err == nil {
+		if err := s.redis.Set(ctx, key, resultJSON, searchCacheTTL).Err(); err != nil {
+			log.Printf("Warning: failed to cache search results: %v", err)
+		}
+	}
+
+	return result, nil
+}
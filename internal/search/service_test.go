@@ -0,0 +1,100 @@
+package search
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestQueryNormalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      Query
+		want    Query
+		wantErr bool
+	}{
+		{
+			name: "defaults type and limit",
+			in:   Query{Text: "Daft Punk"},
+			want: Query{Text: "daft punk", Types: []string{"track"}, Limit: defaultLimit},
+		},
+		{
+			name: "collapses whitespace and lowercases filters",
+			in:   Query{Text: "  Get   Lucky ", Artist: " Daft  PUNK", Genre: "French  House", Year: " 2013 "},
+			want: Query{Text: "get lucky", Artist: "daft punk", Genre: "french house", Year: "2013", Types: []string{"track"}, Limit: defaultLimit},
+		},
+		{
+			name: "sorts and deduplicates types",
+			in:   Query{Text: "x", Types: []string{"Track", "album", " track "}},
+			want: Query{Text: "x", Types: []string{"album", "track"}, Limit: defaultLimit},
+		},
+		{
+			name: "caps the limit",
+			in:   Query{Text: "x", Limit: 500},
+			want: Query{Text: "x", Types: []string{"track"}, Limit: maxLimit},
+		},
+		{
+			name: "accepts a year range",
+			in:   Query{Year: "1990-1999"},
+			want: Query{Year: "1990-1999", Types: []string{"track"}, Limit: defaultLimit},
+		},
+		{
+			name:    "requires text or a filter",
+			in:      Query{Text: "   "},
+			wantErr: true,
+		},
+		{
+			name:    "rejects a malformed year",
+			in:      Query{Text: "x", Year: "90s"},
+			wantErr: true,
+		},
+		{
+			name:    "rejects an unknown type",
+			in:      Query{Text: "x", Types: []string{"podcast"}},
+			wantErr: true,
+		},
+		{
+			name:    "rejects a negative offset",
+			in:      Query{Text: "x", Offset: -1},
+			wantErr: true,
+		},
+		{
+			name:    "rejects paging past Spotify's limit",
+			in:      Query{Text: "x", Limit: 50, Offset: 951},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.in
+			err := q.normalize()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Fatalf("normalize() error = %v, want ErrInvalidQuery", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalize() error = %v", err)
+			}
+			if !reflect.DeepEqual(q, tt.want) {
+				t.Errorf("normalize() = %+v, want %+v", q, tt.want)
+			}
+		})
+	}
+}
+
+func TestEquivalentQueriesShareCacheKey(t *testing.T) {
+	a := Query{Text: "Get Lucky", Types: []string{"track", "album"}}
+	b := Query{Text: "  get   lucky", Types: []string{"Album", "track", "track"}}
+	for _, q := range []*Query{&a, &b} {
+		if err := q.normalize(); err != nil {
+			t.Fatalf("normalize() error = %v", err)
+		}
+	}
+
+	if a.cacheKey() != b.cacheKey() {
+		t.Errorf("cache keys differ: %s != %s", a.cacheKey(), b.cacheKey())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,6 +17,11 @@ type Client struct {
 	clientSecret string
 	redirectURI  string
 	httpClient   *http.Client
+
+	// appToken is a client-credentials token for catalog lookups that are
+	// not made on behalf of a user.
+	appTokenMu sync.Mutex
+	appToken   *TokenResponse
 }
 type TokenResponse struct {
 	AccessToken  string `json:"access_token"`
@@ -35,18 +41,47 @@ type Track struct {
 	Name     string   `json:"name"`
 	Artists  []Artist `json:"artists"`
 	Duration int      `json:"duration_ms"`
+	Explicit bool     `json:"explicit"`
 	Album    Album    `json:"album"`
+	// ExternalIDs holds the track's ISRC among other codes.
+	ExternalIDs map[string]string `json:"external_ids,omitempty"`
+}
+
+// ISRC returns the track's International Standard Recording Code, or ""
+// when Spotify has none.
+func (t *Track) ISRC() string {
+	return t.ExternalIDs["isrc"]
 }
 
 type Artist struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Genres []string `json:"genres,omitempty"`
+	Images []Image  `json:"images,omitempty"`
 }
 
 type Album struct {
-	ID     string  `json:"id"`
-	Name   string  `json:"name"`
-	Images []Image `json:"images"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Images      []Image  `json:"images"`
+	Artists     []Artist `json:"artists,omitempty"`
+	ReleaseDate string   `json:"release_date,omitempty"`
+}
+
+type Playlist struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Description  string            `json:"description"`
+	Public       bool              `json:"public"`
+	ExternalURLs map[string]string `json:"external_urls,omitempty"`
+	Images       []Image           `json:"images"`
+	Owner        struct {
+		ID          string `json:"id"`
+		DisplayName string `json:"display_name"`
+	} `json:"owner"`
+	Tracks struct {
+		Total int `json:"total"`
+	} `json:"tracks"`
 }
 
 type Image struct {
@@ -55,10 +90,39 @@ type Image struct {
 	Width  int    `json:"width"`
 }
 
+// Page holds the paging fields Spotify returns alongside every item list.
+type Page struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+type TrackPage struct {
+	Page
+	Items []Track `json:"items"`
+}
+
+type ArtistPage struct {
+	Page
+	Items []Artist `json:"items"`
+}
+
+type AlbumPage struct {
+	Page
+	Items []Album `json:"items"`
+}
+
+type PlaylistPage struct {
+	Page
+	Items []Playlist `json:"items"`
+}
+
+// SearchResponse only has the pages for the types that were requested.
 type SearchResponse struct {
-	Tracks struct {
-		Items []Track `json:"items"`
-	} `json:"tracks"`
+	Tracks    *TrackPage    `json:"tracks,omitempty"`
+	Artists   *ArtistPage   `json:"artists,omitempty"`
+	Albums    *AlbumPage    `json:"albums,omitempty"`
+	Playlists *PlaylistPage `json:"playlists,omitempty"`
 }
 
 type TopTracksResponse struct {
@@ -79,7 +143,7 @@ func (c *Client) GetAuthURL(state string) string {
 	params.Add("client_id", c.clientID)
 	params.Add("response_type", "code")
 	params.Add("redirect_uri", c.redirectURI)
-	params.Add("scope", "user-read-private user-read-email playlist-read-private user-top-read streaming user-read-playback-state user-modify-playback-state")
+	params.Add("scope", "user-read-private user-read-email playlist-read-private user-top-read playlist-modify-public playlist-modify-private streaming user-read-playback-state user-modify-playback-state")
 	params.Add("state", state)
 
 	return "https://accounts.spotify.com/authorize?" + params.Encode()
@@ -102,6 +166,29 @@ func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*TokenR
 	return c.doTokenRequest(ctx, data)
 }
 
+// AppToken returns a client-credentials access token, requesting a new one
+// when the cached token is about to expire.
+func (c *Client) AppToken(ctx context.Context) (string, error) {
+	c.appTokenMu.Lock()
+	defer c.appTokenMu.Unlock()
+
+	if c.appToken != nil && time.Now().Add(time.Minute).Before(c.appToken.ExpiresAt) {
+		return c.appToken.AccessToken, nil
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+
+	token, err := c.doTokenRequest(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	token.addExpiresAt()
+	c.appToken = token
+
+	return token.AccessToken, nil
+}
+
 func (c *Client) doTokenRequest(ctx context.Context, data url.Values) (*TokenResponse, error) {
 	req, err := http.NewRequestWithContext(ctx, "POST", "https://accounts.spotify.com/api/token", strings.NewReader(data.Encode()))
 	if err != nil {
@@ -131,10 +218,25 @@ func (c *Client) doTokenRequest(ctx context.Context, data url.Values) (*TokenRes
 }
 
 func (c *Client) SearchTracks(ctx context.Context, accessToken, query string, limit int) ([]Track, error) {
+	searchResp, err := c.Search(ctx, accessToken, query, []string{"track"}, limit, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if searchResp.Tracks == nil {
+		return nil, nil
+	}
+	return searchResp.Tracks.Items, nil
+}
+
+// Search queries the catalog for the given item types (track, artist, album,
+// playlist). The query may contain Spotify field filters such as artist:.
+func (c *Client) Search(ctx context.Context, accessToken, query string, types []string, limit, offset int) (*SearchResponse, error) {
 	params := url.Values{}
 	params.Add("q", query)
-	params.Add("type", "track")
+	params.Add("type", strings.Join(types, ","))
 	params.Add("limit", fmt.Sprintf("%d", limit))
+	params.Add("offset", fmt.Sprintf("%d", offset))
 
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/search?"+params.Encode(), nil)
 	if err != nil {
@@ -150,7 +252,7 @@ func (c *Client) SearchTracks(ctx context.Context, accessToken, query string, li
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify: search request failed with status %d", resp.StatusCode)
+		return nil, newAPIError("search", resp)
 	}
 
 	var searchResp SearchResponse
@@ -158,15 +260,22 @@ func (c *Client) SearchTracks(ctx context.Context, accessToken, query string, li
 		return nil, err
 	}
 
-	return searchResp.Tracks.Items, nil
-}
+	// Spotify pads playlist pages with nulls for unavailable playlists.
+	if searchResp.Playlists != nil {
+		items := searchResp.Playlists.Items[:0]
+		for _, p := range searchResp.Playlists.Items {
+			if p.ID != "" {
+				items = append(items, p)
+			}
+		}
+		searchResp.Playlists.Items = items
+	}
 
-func (c *Client) GetTopTracks(ctx context.Context, accessToken string, timeRange string, limit int) ([]Track, error) {
-	params := url.Values{}
-	params.Add("time_range", timeRange) // short_term, medium_term, long_term
-	params.Add("limit", fmt.Sprintf("%d", limit))
+	return &searchResp, nil
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/top/tracks?"+params.Encode(), nil)
+func (c *Client) GetTrack(ctx context.Context, accessToken, trackID string) (*Track, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/tracks/"+url.PathEscape(trackID), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -180,54 +289,68 @@ func (c *Client) GetTopTracks(ctx context.Context, accessToken string, timeRange
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify: top tracks request failed with status %d", resp.StatusCode)
+		return nil, newAPIError("get track", resp)
 	}
 
-	var topTracksResp TopTracksResponse
-	if err := json.NewDecoder(resp.Body).Decode(&topTracksResp); err != nil {
+	var track Track
+	if err := json.NewDecoder(resp.Body).Decode(&track); err != nil {
 		return nil, err
 	}
 
-	return topTracksResp.Items, nil
+	return &track, nil
 }
 
-func (c *Client) PlayTrack(ctx context.Context, accessToken, deviceID, trackURI string) error {
-	payload := map[string]interface{}{
-		"uris": []string{fmt.Sprintf("spotify:track:%s", trackURI)},
-	}
-
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return err
-	}
-
-	url := "https://api.spotify.com/v1/me/player/play"
-	if deviceID != "" {
-		url += "?device_id=" + deviceID
-	}
+func (c *Client) GetTopTracks(ctx context.Context, accessToken string, timeRange string, limit int) ([]Track, error) {
+	params := url.Values{}
+	params.Add("time_range", timeRange) // short_term, medium_term, long_term
+	params.Add("limit", fmt.Sprintf("%d", limit))
 
-	req, err := http.NewRequestWithContext(ctx, "PUT", url, strings.NewReader(string(jsonData)))
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/top/tracks?"+params.Encode(), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Add("Authorization", "Bearer "+accessToken)
-	req.Header.Add("Content-Type", "application/json")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("spotify: play track request failed with status %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("spotify: top tracks request failed with status %d", resp.StatusCode)
 	}
 
-	return nil
+	var topTracksResp TopTracksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&topTracksResp); err != nil {
+		return nil, err
+	}
+
+	return topTracksResp.Items, nil
 }
 
-func (c *Client) GetUser(ctx context.Context, accessToken string) (interface{}, error) {
+// PlayTrack starts a single track on deviceID, or on the active device when
+// deviceID is empty.
+func (c *Client) PlayTrack(ctx context.Context, accessToken, deviceID, trackID string) error {
+	return c.Play(ctx, accessToken, PlayOptions{
+		DeviceID: deviceID,
+		TrackIDs: []string{trackID},
+	})
+}
+
+// User is the Spotify profile of the user an access token belongs to.
+type User struct {
+	ID          string  `json:"id"`
+	DisplayName string  `json:"display_name"`
+	Email       string  `json:"email,omitempty"`
+	Country     string  `json:"country,omitempty"`
+	Product     string  `json:"product,omitempty"`
+	Images      []Image `json:"images,omitempty"`
+}
+
+// GetUser returns the profile of the token's user.
+func (c *Client) GetUser(ctx context.Context, accessToken string) (*User, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me", nil)
 	if err != nil {
 		return nil, err
@@ -242,8 +365,13 @@ func (c *Client) GetUser(ctx context.Context, accessToken string) (interface{},
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify: get user request failed with status %d", resp.StatusCode)
+		return nil, newAPIError("get user", resp)
+	}
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, err
 	}
 
-	return resp.Body, nil
+	return &user, nil
 }
@@ -0,0 +1,89 @@
+package spotify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrNoActiveDevice  = errors.New("spotify: no active device")
+	ErrPremiumRequired = errors.New("spotify: premium account required")
+	ErrUnauthorized    = errors.New("spotify: access token rejected")
+	ErrRateLimited     = errors.New("spotify: rate limited")
+	ErrNotFound        = errors.New("spotify: resource not found")
+)
+
+// Reasons returned by the Web API in the error object of player endpoints.
+const (
+	reasonNoActiveDevice  = "NO_ACTIVE_DEVICE"
+	reasonPremiumRequired = "PREMIUM_REQUIRED"
+)
+
+// APIError is a non-2xx response from the Spotify Web API.
+type APIError struct {
+	Op         string
+	StatusCode int
+	Reason     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("spotify: %s request failed with status %d: %s", e.Op, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("spotify: %s request failed with status %d", e.Op, e.StatusCode)
+}
+
+// Is lets callers match an APIError against the package sentinels with errors.Is.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNoActiveDevice:
+		return e.Reason == reasonNoActiveDevice
+	case ErrPremiumRequired:
+		return e.Reason == reasonPremiumRequired
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound && e.Reason == ""
+	}
+	return false
+}
+
+// newAPIError builds an APIError from a failed response, decoding the
+// {"error": {"status", "message", "reason"}} body when Spotify sends one.
+func newAPIError(op string, resp *http.Response) *APIError {
+	apiErr := &APIError{Op: op, StatusCode: resp.StatusCode}
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Reason  string `json:"reason"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
+		apiErr.Message = body.Error.Message
+		apiErr.Reason = body.Error.Reason
+	}
+
+	return apiErr
+}
+
+// newPlayerAPIError is newAPIError for /me/player endpoints, where a bare 404
+// means there is no active device and a bare 403 means the account is not
+// premium.
+func newPlayerAPIError(op string, resp *http.Response) *APIError {
+	apiErr := newAPIError(op, resp)
+	if apiErr.Reason == "" {
+		switch apiErr.StatusCode {
+		case http.StatusNotFound:
+			apiErr.Reason = reasonNoActiveDevice
+		case http.StatusForbidden:
+			apiErr.Reason = reasonPremiumRequired
+		}
+	}
+	return apiErr
+}
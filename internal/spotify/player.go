@@ -0,0 +1,187 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const playerURL = "https://api.spotify.com/v1/me/player"
+
+type Device struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Type             string `json:"type"`
+	IsActive         bool   `json:"is_active"`
+	IsRestricted     bool   `json:"is_restricted"`
+	IsPrivateSession bool   `json:"is_private_session"`
+	VolumePercent    int    `json:"volume_percent"`
+}
+
+type PlaybackState struct {
+	Device       Device `json:"device"`
+	IsPlaying    bool   `json:"is_playing"`
+	ProgressMs   int    `json:"progress_ms"`
+	ShuffleState bool   `json:"shuffle_state"`
+	RepeatState  string `json:"repeat_state"`
+	Item         *Track `json:"item"`
+}
+
+// PlayOptions selects what to start playing. An empty TrackIDs resumes the
+// current context on the device.
+type PlayOptions struct {
+	DeviceID   string
+	TrackIDs   []string
+	PositionMs int
+}
+
+// GetPlaybackState returns the user's current playback, or nil when nothing
+// is playing on any device.
+func (c *Client) GetPlaybackState(ctx context.Context, accessToken string) (*PlaybackState, error) {
+	resp, err := c.doPlayerRequest(ctx, "playback state", accessToken, http.MethodGet, "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	var state PlaybackState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func (c *Client) GetDevices(ctx context.Context, accessToken string) ([]Device, error) {
+	resp, err := c.doPlayerRequest(ctx, "devices", accessToken, http.MethodGet, "/devices", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var devicesResp struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&devicesResp); err != nil {
+		return nil, err
+	}
+
+	return devicesResp.Devices, nil
+}
+
+func (c *Client) Play(ctx context.Context, accessToken string, opts PlayOptions) error {
+	var body interface{}
+	if len(opts.TrackIDs) > 0 {
+		uris := make([]string, len(opts.TrackIDs))
+		for i, id := range opts.TrackIDs {
+			uris[i] = fmt.Sprintf("spotify:track:%s", id)
+		}
+		body = map[string]interface{}{
+			"uris":        uris,
+			"position_ms": opts.PositionMs,
+		}
+	}
+
+	return c.playerCommand(ctx, "play", accessToken, http.MethodPut, "/play", deviceQuery(opts.DeviceID), body)
+}
+
+func (c *Client) Pause(ctx context.Context, accessToken, deviceID string) error {
+	return c.playerCommand(ctx, "pause", accessToken, http.MethodPut, "/pause", deviceQuery(deviceID), nil)
+}
+
+func (c *Client) Seek(ctx context.Context, accessToken, deviceID string, positionMs int) error {
+	query := deviceQuery(deviceID)
+	query.Set("position_ms", strconv.Itoa(positionMs))
+	return c.playerCommand(ctx, "seek", accessToken, http.MethodPut, "/seek", query, nil)
+}
+
+func (c *Client) SkipToNext(ctx context.Context, accessToken, deviceID string) error {
+	return c.playerCommand(ctx, "skip to next", accessToken, http.MethodPost, "/next", deviceQuery(deviceID), nil)
+}
+
+func (c *Client) SkipToPrevious(ctx context.Context, accessToken, deviceID string) error {
+	return c.playerCommand(ctx, "skip to previous", accessToken, http.MethodPost, "/previous", deviceQuery(deviceID), nil)
+}
+
+func (c *Client) SetVolume(ctx context.Context, accessToken, deviceID string, volumePercent int) error {
+	query := deviceQuery(deviceID)
+	query.Set("volume_percent", strconv.Itoa(volumePercent))
+	return c.playerCommand(ctx, "volume", accessToken, http.MethodPut, "/volume", query, nil)
+}
+
+// TransferPlayback moves playback to deviceID. When play is false the
+// device keeps the current paused/playing state.
+func (c *Client) TransferPlayback(ctx context.Context, accessToken, deviceID string, play bool) error {
+	body := map[string]interface{}{
+		"device_ids": []string{deviceID},
+		"play":       play,
+	}
+	return c.playerCommand(ctx, "transfer playback", accessToken, http.MethodPut, "", nil, body)
+}
+
+func deviceQuery(deviceID string) url.Values {
+	query := url.Values{}
+	if deviceID != "" {
+		query.Set("device_id", deviceID)
+	}
+	return query
+}
+
+// playerCommand sends a request that only reports success or failure.
+func (c *Client) playerCommand(ctx context.Context, op, accessToken, method, path string, query url.Values, body interface{}) error {
+	resp, err := c.doPlayerRequest(ctx, op, accessToken, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// doPlayerRequest calls a /me/player endpoint and converts any non-2xx
+// response into an *APIError. The caller must close the body on success.
+func (c *Client) doPlayerRequest(ctx context.Context, op, accessToken, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	endpoint := playerURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, newPlayerAPIError(op, resp)
+	}
+
+	return resp, nil
+}
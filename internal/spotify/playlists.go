@@ -0,0 +1,105 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+const (
+	apiURL = "https://api.spotify.com/v1"
+
+	// maxPlaylistItemsPerRequest is the most tracks Spotify accepts in one
+	// add items request.
+	maxPlaylistItemsPerRequest = 100
+)
+
+// PlaylistOptions describes a playlist to create.
+type PlaylistOptions struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Public      bool   `json:"public"`
+}
+
+// CreatePlaylist creates an empty playlist owned by userID, who must be the
+// token's user.
+func (c *Client) CreatePlaylist(ctx context.Context, accessToken, userID string, opts PlaylistOptions) (*Playlist, error) {
+	resp, err := c.doJSONRequest(ctx, "create playlist", accessToken, http.MethodPost,
+		apiURL+"/users/"+url.PathEscape(userID)+"/playlists", opts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var playlist Playlist
+	if err := json.NewDecoder(resp.Body).Decode(&playlist); err != nil {
+		return nil, err
+	}
+	return &playlist, nil
+}
+
+// AddPlaylistItems appends tracks to a playlist in order, 100 per request.
+// If a request fails the tracks before it stay added. It returns the
+// playlist's final snapshot ID.
+func (c *Client) AddPlaylistItems(ctx context.Context, accessToken, playlistID string, trackIDs []string) (string, error) {
+	var snapshotID string
+	for start := 0; start < len(trackIDs); start += maxPlaylistItemsPerRequest {
+		end := start + maxPlaylistItemsPerRequest
+		if end > len(trackIDs) {
+			end = len(trackIDs)
+		}
+
+		uris := make([]string, 0, end-start)
+		for _, id := range trackIDs[start:end] {
+			uris = append(uris, "spotify:track:"+id)
+		}
+
+		resp, err := c.doJSONRequest(ctx, "add playlist items", accessToken, http.MethodPost,
+			apiURL+"/playlists/"+url.PathEscape(playlistID)+"/tracks", map[string]interface{}{"uris": uris})
+		if err != nil {
+			return snapshotID, err
+		}
+
+		var result struct {
+			SnapshotID string `json:"snapshot_id"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return snapshotID, err
+		}
+		snapshotID = result.SnapshotID
+	}
+	return snapshotID, nil
+}
+
+// doJSONRequest sends body as JSON and converts any non-2xx response into
+// an *APIError. The caller must close the body on success.
+func (c *Client) doJSONRequest(ctx context.Context, op, accessToken, method, endpoint string, body interface{}) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, newAPIError(op, resp)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,62 @@
+package ws
+
+import "time"
+
+// Config tunes WebSocket connections.
+type Config struct {
+	// SendQueueSize is how many messages may wait for a connection before
+	// SlowConsumerPolicy applies.
+	SendQueueSize      int
+	SlowConsumerPolicy SlowConsumerPolicy
+	// WriteTimeout bounds each write; a client that cannot take a message
+	// in time is disconnected.
+	WriteTimeout time.Duration
+	// PingInterval is how often clients are pinged. It must be shorter
+	// than PongWait.
+	PingInterval time.Duration
+	// PongWait is how long a client may stay silent, sending neither
+	// messages nor pongs, before it is considered gone and reaped.
+	PongWait time.Duration
+	// MaxMessageSize is the largest message in bytes a client may send.
+	MaxMessageSize int64
+}
+
+// DefaultConfig returns the settings used unless overridden.
+func DefaultConfig() Config {
+	return Config{
+		SendQueueSize:      64,
+		SlowConsumerPolicy: PolicyCoalesce,
+		WriteTimeout:       10 * time.Second,
+		PingInterval:       25 * time.Second,
+		PongWait:           60 * time.Second,
+		MaxMessageSize:     4096,
+	}
+}
+
+// withDefaults fills unset fields from DefaultConfig and keeps pings
+// frequent enough to arrive within PongWait.
+func (c Config) withDefaults() Config {
+	def := DefaultConfig()
+	if c.SendQueueSize <= 0 {
+		c.SendQueueSize = def.SendQueueSize
+	}
+	if c.SlowConsumerPolicy == "" {
+		c.SlowConsumerPolicy = def.SlowConsumerPolicy
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = def.WriteTimeout
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = def.PongWait
+	}
+	if c.PingInterval <= 0 {
+		c.PingInterval = def.PingInterval
+	}
+	if c.PingInterval >= c.PongWait {
+		c.PingInterval = c.PongWait * 9 / 10
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = def.MaxMessageSize
+	}
+	return c
+}
@@ -0,0 +1,40 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigWithDefaults(t *testing.T) {
+	def := DefaultConfig()
+
+	tests := []struct {
+		name         string
+		cfg          Config
+		wantPing     time.Duration
+		wantPongWait time.Duration
+	}{
+		{name: "unset", wantPing: def.PingInterval, wantPongWait: def.PongWait},
+		{name: "negative ping", cfg: Config{PingInterval: -time.Second}, wantPing: def.PingInterval, wantPongWait: def.PongWait},
+		{name: "custom ping", cfg: Config{PingInterval: 10 * time.Second}, wantPing: 10 * time.Second, wantPongWait: def.PongWait},
+		{name: "ping as long as pong wait", cfg: Config{PingInterval: time.Minute, PongWait: time.Minute}, wantPing: 54 * time.Second, wantPongWait: time.Minute},
+		{name: "ping longer than pong wait", cfg: Config{PingInterval: 2 * time.Minute}, wantPing: 54 * time.Second, wantPongWait: def.PongWait},
+		{name: "default ping longer than short pong wait", cfg: Config{PongWait: 20 * time.Second}, wantPing: 18 * time.Second, wantPongWait: 20 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cfg.withDefaults()
+			if got.PingInterval != tt.wantPing {
+				t.Errorf("PingInterval = %s, want %s", got.PingInterval, tt.wantPing)
+			}
+			if got.PongWait != tt.wantPongWait {
+				t.Errorf("PongWait = %s, want %s", got.PongWait, tt.wantPongWait)
+			}
+		})
+	}
+
+	if got := (Config{}).withDefaults(); got != def {
+		t.Errorf("withDefaults() of the zero Config = %+v, want DefaultConfig() %+v", got, def)
+	}
+}
@@ -0,0 +1,227 @@
+package ws
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// SlowConsumerPolicy says what happens when a connection's outbound queue
+// is full.
+type SlowConsumerPolicy string
+
+const (
+	// PolicyDisconnect closes the connection.
+	PolicyDisconnect SlowConsumerPolicy = "disconnect"
+	// PolicyDropOldest discards the oldest queued message.
+	PolicyDropOldest SlowConsumerPolicy = "drop_oldest"
+	// PolicyCoalesce keeps only the latest of messages that replace one
+	// another, such as queue and playback snapshots, and disconnects if
+	// the queue is still full of messages that cannot be dropped.
+	PolicyCoalesce SlowConsumerPolicy = "coalesce"
+)
+
+// ParseSlowConsumerPolicy checks a policy name from configuration.
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	switch policy := SlowConsumerPolicy(name); policy {
+	case PolicyDisconnect, PolicyDropOldest, PolicyCoalesce:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown slow consumer policy %q", name)
+}
+
+// closeGracePeriod bounds the write of the close frame.
+const closeGracePeriod = time.Second
+
+// outbound is a queued message. Messages with the same non-empty key
+// supersede each other under PolicyCoalesce.
+type outbound struct {
+	data []byte
+	key  string
+}
+
+// Conn is one WebSocket connection to a room. Messages are queued with Send
+// and written by a single writer goroutine, as gorilla/websocket allows
+// only one concurrent writer.
+type Conn struct {
+	// id tells apart the connections of a user who is connected more
+	// than once.
+	id     string
+	roomID string
+	userID string
+	ws     *websocket.Conn
+	cfg    Config
+	// version is the protocol version agreed at connect time.
+	version int
+
+	mu    sync.Mutex
+	queue []outbound
+	// wake has room for one signal so Send never blocks on the writer.
+	wake chan struct{}
+
+	// done is closed, with closeCode and closeText set, when the
+	// connection should close.
+	done      chan struct{}
+	closeOnce sync.Once
+	closeCode int
+	closeText string
+}
+
+func newConn(roomID, userID string, ws *websocket.Conn, cfg Config, version int) *Conn {
+	return &Conn{
+		id:      uuid.New().String(),
+		roomID:  roomID,
+		userID:  userID,
+		ws:      ws,
+		cfg:     cfg,
+		version: version,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Send queues data for the writer without blocking. key marks messages
+// that a later one with the same key makes stale; "" means the message
+// must be delivered. What happens when the queue is full depends on the
+// slow consumer policy.
+func (c *Conn) Send(data []byte, key string) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	c.mu.Lock()
+	ok := c.push(outbound{data: data, key: key})
+	c.mu.Unlock()
+
+	if !ok {
+		c.Close(websocket.ClosePolicyViolation, "too slow")
+		return
+	}
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push adds msg to the queue, making room as the policy allows. It
+// reports false if the connection has to go. c.mu must be held.
+func (c *Conn) push(msg outbound) bool {
+	if c.cfg.SlowConsumerPolicy == PolicyCoalesce && msg.key != "" {
+		for i := range c.queue {
+			if c.queue[i].key == msg.key {
+				// Drop the stale copy and queue the update at the back,
+				// keeping it in order with what was sent in between
+				c.queue = append(c.queue[:i], c.queue[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if len(c.queue) >= c.cfg.SendQueueSize {
+		switch c.cfg.SlowConsumerPolicy {
+		case PolicyDropOldest:
+			c.queue = c.queue[1:]
+		case PolicyCoalesce:
+			if !c.dropOldestCoalescable() {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	c.queue = append(c.queue, msg)
+	return true
+}
+
+func (c *Conn) dropOldestCoalescable() bool {
+	for i := range c.queue {
+		if c.queue[i].key != "" {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Close asks the writer to send a close frame and disconnect. Only the
+// first call has any effect.
+func (c *Conn) Close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeText = text
+		close(c.done)
+	})
+}
+
+// writePump writes queued messages and pings until the connection is
+// closed or a write fails or times out. Closing the socket on the way out
+// ends the read loop too.
+func (c *Conn) writePump() {
+	ping := time.NewTicker(c.cfg.PingInterval)
+	defer func() {
+		ping.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case <-ping.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.cfg.WriteTimeout)); err != nil {
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.wake:
+			for _, msg := range c.drain() {
+				c.ws.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+				if err := c.ws.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+					c.Close(websocket.CloseAbnormalClosure, "")
+					return
+				}
+			}
+		case <-c.done:
+			if c.closeCode != websocket.CloseAbnormalClosure {
+				frame := websocket.FormatCloseMessage(c.closeCode, c.closeText)
+				c.ws.WriteControl(websocket.CloseMessage, frame, time.Now().Add(closeGracePeriod))
+			}
+			return
+		}
+	}
+}
+
+// readMessage waits for the next message from the client. Messages and
+// pongs each give the client another PongWait to send the next one; a
+// client that stays silent longer times out.
+func (c *Conn) readMessage() ([]byte, error) {
+	_, message, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	c.ws.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
+	return message, nil
+}
+
+// startReading applies the read limits and deadline. It must be called
+// before the first readMessage.
+func (c *Conn) startReading() {
+	c.ws.SetReadLimit(c.cfg.MaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(c.cfg.PongWait))
+	})
+}
+
+// drain takes everything queued so far.
+func (c *Conn) drain() []outbound {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msgs := c.queue
+	c.queue = nil
+	return msgs
+}
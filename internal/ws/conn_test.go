@@ -0,0 +1,214 @@
+package ws
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dial connects a client to a test server and returns both ends of the
+// connection.
+func dial(t *testing.T) (server, client *websocket.Conn) {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		conns <- c
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case server = <-conns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server side of the connection never arrived")
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+func testConfig(queueSize int, policy SlowConsumerPolicy) Config {
+	cfg := DefaultConfig()
+	cfg.SendQueueSize = queueSize
+	cfg.SlowConsumerPolicy = policy
+	return cfg.withDefaults()
+}
+
+func isClosed(c *Conn) bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSendSlowConsumerPolicies(t *testing.T) {
+	type send struct {
+		data, key string
+	}
+	tests := []struct {
+		name       string
+		policy     SlowConsumerPolicy
+		sends      []send
+		wantQueue  []string
+		wantClosed bool
+	}{
+		{
+			name:      "disconnect keeps messages while there is room",
+			policy:    PolicyDisconnect,
+			sends:     []send{{"a", ""}, {"b", "k"}},
+			wantQueue: []string{"a", "b"},
+		},
+		{
+			name:       "disconnect closes when full",
+			policy:     PolicyDisconnect,
+			sends:      []send{{"a", ""}, {"b", ""}, {"c", ""}},
+			wantQueue:  []string{"a", "b"},
+			wantClosed: true,
+		},
+		{
+			name:      "drop oldest discards the head",
+			policy:    PolicyDropOldest,
+			sends:     []send{{"a", ""}, {"b", ""}, {"c", ""}, {"d", ""}},
+			wantQueue: []string{"c", "d"},
+		},
+		{
+			name:      "coalesce replaces a stale message and requeues it at the back",
+			policy:    PolicyCoalesce,
+			sends:     []send{{"state1", "state"}, {"event", ""}, {"state2", "state"}},
+			wantQueue: []string{"event", "state2"},
+		},
+		{
+			name:      "coalesce drops the oldest coalescable message when full",
+			policy:    PolicyCoalesce,
+			sends:     []send{{"event1", ""}, {"state", "state"}, {"event2", ""}},
+			wantQueue: []string{"event1", "event2"},
+		},
+		{
+			name:       "coalesce closes when nothing can be dropped",
+			policy:     PolicyCoalesce,
+			sends:      []send{{"event1", ""}, {"event2", ""}, {"event3", ""}},
+			wantQueue:  []string{"event1", "event2"},
+			wantClosed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a writer the queue only grows, so each policy
+			// decides what happens once it holds two messages
+			c := newConn("room", "user", nil, testConfig(2, tt.policy), ProtocolVersion)
+			for _, s := range tt.sends {
+				c.Send([]byte(s.data), s.key)
+			}
+
+			var got []string
+			for _, msg := range c.drain() {
+				got = append(got, string(msg.data))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.wantQueue, ",") {
+				t.Errorf("queue = %v, want %v", got, tt.wantQueue)
+			}
+			if closed := isClosed(c); closed != tt.wantClosed {
+				t.Errorf("closed = %v, want %v", closed, tt.wantClosed)
+			}
+			if tt.wantClosed && c.closeCode != websocket.ClosePolicyViolation {
+				t.Errorf("close code = %d, want %d", c.closeCode, websocket.ClosePolicyViolation)
+			}
+		})
+	}
+}
+
+func TestSendAfterCloseIsDropped(t *testing.T) {
+	c := newConn("room", "user", nil, testConfig(2, PolicyDisconnect), ProtocolVersion)
+	c.Close(websocket.CloseNormalClosure, "")
+	c.Send([]byte("late"), "")
+
+	if queued := c.drain(); len(queued) != 0 {
+		t.Errorf("queued %d messages after close, want none", len(queued))
+	}
+}
+
+func TestWritePumpDeliversAndCloses(t *testing.T) {
+	server, client := dial(t)
+	c := newConn("room", "user", server, testConfig(8, PolicyDisconnect), ProtocolVersion)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		c.writePump()
+	}()
+
+	c.Send([]byte("hello"), "")
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("message = %q, want %q", data, "hello")
+	}
+
+	c.Close(websocket.CloseNormalClosure, "bye")
+	_, _, err = client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("read after close = %v, want a normal close frame", err)
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writePump did not return after Close")
+	}
+}
+
+func TestWritePumpWriteDeadline(t *testing.T) {
+	server, _ := dial(t)
+	cfg := testConfig(4, PolicyDropOldest)
+	cfg.WriteTimeout = 100 * time.Millisecond
+	c := newConn("room", "user", server, cfg, ProtocolVersion)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		c.writePump()
+	}()
+
+	// The client never reads, so once the socket buffers fill up a write
+	// blocks until its deadline passes
+	payload := bytes.Repeat([]byte("x"), 1<<20)
+	deadline := time.After(10 * time.Second)
+	for !isClosed(c) {
+		select {
+		case <-deadline:
+			t.Fatal("connection was not closed after writes stalled")
+		case <-time.After(10 * time.Millisecond):
+		}
+		c.Send(payload, "")
+	}
+
+	if c.closeCode != websocket.CloseAbnormalClosure {
+		t.Errorf("close code = %d, want %d", c.closeCode, websocket.CloseAbnormalClosure)
+	}
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writePump did not return after a write timed out")
+	}
+}
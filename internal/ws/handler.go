@@ -3,13 +3,18 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/music-queue-system/internal/room"
 	"github.com/music-queue-system/pkg/events"
+	"github.com/music-queue-system/pkg/models"
 )
 
 var upgrader = websocket.Upgrader{
@@ -20,27 +25,52 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-type VoteMessage struct {
-	TrackID string `json:"track_id"`
-	Value   int    `json:"value"`
-}
+// consumerRetryDelay is the pause before the event consumer restarts after
+// an error.
+const consumerRetryDelay = 5 * time.Second
 
-type SongStartMessage struct {
-	TrackID   string `json:"track_id"`
-	TrackName string `json:"track_name"`
-}
+// commandTimeout bounds the work done for one client command.
+const commandTimeout = 10 * time.Second
+
+// keepActiveInterval is how often rooms with connections on this instance
+// are marked active, well within any idle timeout.
+const keepActiveInterval = time.Minute
 
 type Handler struct {
-	// Map of roomID -> map of connectionID -> *websocket.Conn
-	rooms    map[string]map[string]*websocket.Conn
-	mu       sync.RWMutex
-	events   *events.KafkaClient
+	cfg         Config
+	hub         *hub
+	events      *events.KafkaClient
+	roomService *room.Service
 }
 
-func NewHandler(events *events.KafkaClient) *Handler {
+func NewHandler(events *events.KafkaClient, roomService *room.Service, cfg Config) *Handler {
 	return &Handler{
-		rooms:  make(map[string]map[string]*websocket.Conn),
-		events: events,
+		cfg:         cfg.withDefaults(),
+		hub:         newHub(),
+		events:      events,
+		roomService: roomService,
+	}
+}
+
+// Run delivers room events to this instance's connections until ctx is
+// done, then disconnects every client. It is the only consumer of the
+// Kafka reader.
+func (h *Handler) Run(ctx context.Context) {
+	defer h.hub.shutdown()
+	go h.keepRoomsActive(ctx)
+
+	for {
+		err := h.events.ConsumeEvents(ctx, h.dispatch)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Event consumer stopped, restarting: %v", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(consumerRetryDelay):
+		}
 	}
 }
 
@@ -51,175 +81,266 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	version, subprotocol, ok := negotiateVersion(websocket.Subprotocols(c.Request))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":              "unsupported protocol version",
+			"code":               CodeUnsupportedVersion,
+			"supported_versions": supportedVersions,
+		})
+		return
+	}
+
+	// Non-members, banned users and unknown rooms are refused before the upgrade
+	if err := h.roomService.RequireMember(c.Request.Context(), roomID, c.GetString("user_id")); err != nil {
+		status := http.StatusForbidden
+		switch {
+		case errors.Is(err, room.ErrRoomNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, room.ErrRoomClosed):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Echo the chosen subprotocol; clients that offered none get no header
+	var header http.Header
+	if subprotocol != "" {
+		header = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, header)
 	if err != nil {
 		log.Printf("Failed to upgrade connection: %v", err)
 		return
 	}
 
-	connID := c.GetString("user_id") // Set by auth middleware
-	h.addConnection(roomID, connID, conn)
-	defer h.removeConnection(roomID, connID)
+	userID := c.GetString("user_id") // Set by auth middleware
+	wsConn := newConn(roomID, userID, conn, h.cfg, version)
+	wsConn.reply(TypeWelcome, "", WelcomePayload{
+		Version:           version,
+		SupportedVersions: supportedVersions,
+		ConnectionID:      wsConn.id,
+		UserID:            userID,
+	})
+
+	// Tell the new client what is playing before it receives live events
+	if err := h.sendPlaybackState(c.Request.Context(), wsConn); err != nil {
+		log.Printf("Failed to send playback state: %v", err)
+	}
+
+	first, ok := h.hub.register(wsConn)
+	if !ok {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server shutting down"),
+			time.Now().Add(closeGracePeriod))
+		conn.Close()
+		return
+	}
+	h.roomService.KeepActive(c.Request.Context(), roomID)
+	h.roomService.Connected(c.Request.Context(), roomID, userID, wsConn.id)
 
-	// Start consuming Kafka events for this room
-	go h.consumeEvents(roomID)
+	// Other tabs and devices of a user who is already here join silently
+	if first {
+		h.hub.broadcast(roomID, TypeUserJoined, PresencePayload{UserID: userID}, "")
+	}
+	defer func() {
+		// The request context may already be cancelled by now
+		h.roomService.Disconnected(context.Background(), roomID, userID, wsConn.id)
+		if h.hub.unregister(wsConn) {
+			h.hub.broadcast(roomID, TypeUserLeft, PresencePayload{UserID: userID}, "")
+		}
+	}()
 
 	// Handle incoming messages
+	wsConn.startReading()
 	for {
-		_, message, err := conn.ReadMessage()
+		message, err := wsConn.readMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			switch {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				// Unanswered pings; the deferred unregister reaps it
+				log.Printf("Reaping unresponsive connection of user %s in room %s", userID, roomID)
+			case errors.Is(err, websocket.ErrReadLimit):
+				// gorilla/websocket has already sent the close frame
+				log.Printf("Dropping connection of user %s in room %s: message too big", userID, roomID)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
 
-		var msg map[string]interface{}
-		if err := json.Unmarshal(message, &msg); err != nil {
-			log.Printf("Failed to parse message: %v", err)
+		var env Envelope
+		if err := json.Unmarshal(message, &env); err != nil {
+			wsConn.replyError("", CodeBadMessage, "message is not a valid envelope")
 			continue
 		}
-
-		// Handle different message types
-		switch msg["type"] {
-		case "vote":
-			var voteMsg VoteMessage
-			if err := json.Unmarshal(message, &voteMsg); err != nil {
-				log.Printf("Failed to parse vote message: %v", err)
-				continue
-			}
-			h.handleVote(roomID, connID, voteMsg)
-		case "add_song":
-			h.handleAddSong(roomID, connID, msg)
-		case "song_start":
-			var songMsg SongStartMessage
-			if err := json.Unmarshal(message, &songMsg); err != nil {
-				log.Printf("Failed to parse song start message: %v", err)
-				continue
-			}
-			h.handleSongStart(roomID, songMsg)
-		}
+		h.handleCommand(wsConn, env)
 	}
 }
 
-func (h *Handler) addConnection(roomID, connID string, conn *websocket.Conn) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	if _, exists := h.rooms[roomID]; !exists {
-		h.rooms[roomID] = make(map[string]*websocket.Conn)
+// handleCommand runs a client command and answers it with an ack or an
+// error carrying the command's request ID.
+func (h *Handler) handleCommand(c *Conn, env Envelope) {
+	if env.RequestID == "" {
+		c.replyError("", CodeBadMessage, "request_id is required")
+		return
+	}
+	if env.V != c.version {
+		c.replyError(env.RequestID, CodeUnsupportedVersion,
+			fmt.Sprintf("this connection uses protocol version %d", c.version))
+		return
 	}
-	h.rooms[roomID][connID] = conn
-
-	// Notify others that a user has joined
-	h.broadcastToRoom(roomID, map[string]interface{}{
-		"type":    "user_joined",
-		"user_id": connID,
-	})
-}
 
-func (h *Handler) removeConnection(roomID, connID string) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
 
-	if room, exists := h.rooms[roomID]; exists {
-		if conn, exists := room[connID]; exists {
-			conn.Close()
-			delete(room, connID)
+	var result interface{}
+	var err error
+	switch env.Type {
+	case TypeVote:
+		var payload VotePayload
+		if !decodePayload(c, env, &payload) {
+			return
+		}
+		err = h.roomService.Vote(ctx, c.roomID, c.userID, payload.QueueItemID, payload.Value, payload.Weight)
+	case TypeRetractVote:
+		var payload RetractVotePayload
+		if !decodePayload(c, env, &payload) {
+			return
+		}
+		err = h.roomService.RetractVote(ctx, c.roomID, c.userID, payload.QueueItemID)
+	case TypeAddSong:
+		var payload AddSongPayload
+		if !decodePayload(c, env, &payload) {
+			return
 		}
-		if len(room) == 0 {
-			delete(h.rooms, roomID)
+		result, err = h.roomService.AddToQueue(ctx, c.roomID, c.userID, payload.TrackID)
+	case TypeSkipVote:
+		var payload SkipVotePayload
+		if !decodePayload(c, env, &payload) {
+			return
 		}
+		result, err = h.roomService.VoteSkip(ctx, c.roomID, c.userID)
+	default:
+		c.replyError(env.RequestID, CodeUnknownType, fmt.Sprintf("unknown message type %q", env.Type))
+		return
 	}
 
-	// Notify others that a user has left
-	h.broadcastToRoom(roomID, map[string]interface{}{
-		"type":    "user_left",
-		"user_id": connID,
-	})
+	if err != nil {
+		code, message := room.ErrorCode(err)
+		if code == CodeInternal {
+			log.Printf("Failed to handle %s from user %s in room %s: %v", env.Type, c.userID, c.roomID, err)
+		}
+		c.replyError(env.RequestID, code, message)
+		return
+	}
+	c.ack(env.RequestID, result)
 }
 
-func (h *Handler) broadcastToRoom(roomID string, message interface{}) {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// decodePayload unmarshals a command's payload, answering with an error if
+// it does not fit. A missing payload leaves target zero.
+func decodePayload(c *Conn, env Envelope, target interface{}) bool {
+	if len(env.Payload) == 0 {
+		return true
+	}
+	if err := json.Unmarshal(env.Payload, target); err != nil {
+		c.replyError(env.RequestID, CodeInvalidPayload, fmt.Sprintf("invalid %s payload", env.Type))
+		return false
+	}
+	return true
+}
 
-	if room, exists := h.rooms[roomID]; exists {
-		messageJSON, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("Failed to marshal message: %v", err)
-			return
-		}
+// keepRoomsActive marks the rooms this instance serves as active so the
+// idle sweeper, which may run on any instance, keeps them open, and keeps
+// this instance's connections counted in their rooms' audiences.
+func (h *Handler) keepRoomsActive(ctx context.Context) {
+	ticker := time.NewTicker(keepActiveInterval)
+	defer ticker.Stop()
 
-		for _, conn := range room {
-			if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
-				log.Printf("Failed to send message: %v", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, roomID := range h.hub.roomIDs() {
+				h.roomService.KeepActive(ctx, roomID)
+				for _, c := range h.hub.conns(roomID) {
+					h.roomService.Connected(ctx, roomID, c.userID, c.id)
+				}
 			}
 		}
 	}
 }
 
-func (h *Handler) consumeEvents(roomID string) {
-	ctx := context.Background()
-	err := h.events.ConsumeEvents(ctx, func(event events.Event) error {
-		if event.RoomID == roomID {
-			h.broadcastToRoom(roomID, event)
-		}
-		return nil
-	})
-	if err != nil {
-		log.Printf("Failed to consume events: %v", err)
+// dispatch delivers a room event to the room's clients on this instance.
+func (h *Handler) dispatch(event events.Event) error {
+	h.hub.broadcast(event.RoomID, TypeEvent, event, coalesceKey(event))
+	switch event.Type {
+	case events.EventTypeMemberRoleChanged:
+		h.dropBannedMember(event.RoomID, event)
+	case events.EventTypeMemberLeft:
+		// Someone who left the room no longer receives its events
+		h.hub.closeUser(event.RoomID, event.UserID, websocket.CloseNormalClosure, "left the room")
+	case events.EventTypeRoomClosed:
+		h.hub.closeRoom(event.RoomID, websocket.CloseNormalClosure, "room closed")
 	}
+	return nil
 }
 
-func (h *Handler) handleVote(roomID, userID string, msg VoteMessage) {
-	// Publish vote event
-	payload := events.SongVotedPayload{
-		TrackID: msg.TrackID,
-		UserID:  userID,
-		Value:   msg.Value,
+func (h *Handler) sendPlaybackState(ctx context.Context, c *Conn) error {
+	state, err := h.roomService.NowPlaying(ctx, c.roomID)
+	if err != nil {
+		return err
 	}
 
-	if err := h.events.PublishEvent(context.Background(), "song-votes", payload); err != nil {
-		log.Printf("Failed to publish vote event: %v", err)
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
 	}
-}
 
-func (h *Handler) handleSongStart(roomID string, msg SongStartMessage) {
-	// Publish song start event
-	payload := events.SongStartedPayload{
-		TrackID:   msg.TrackID,
-		TrackName: msg.TrackName,
+	message, err := encodeEnvelope(c.version, TypeEvent, "", EventPayload{
+		Type:      events.EventTypePlaybackState,
+		RoomID:    c.roomID,
+		Timestamp: time.Now(),
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := h.events.PublishEvent(context.Background(), "song-starts", payload); err != nil {
-		log.Printf("Failed to publish song start event: %v", err)
-	}
+	c.Send(message, string(events.EventTypePlaybackState))
+	return nil
 }
 
-func (h *Handler) handleAddSong(roomID, userID string, msg map[string]interface{}) {
-	trackID, ok := msg["track_id"].(string)
-	if !ok {
-		return
+// coalesceKey groups events that only carry the latest state of something,
+// so a slow client can skip straight to the newest one. Events that must
+// all be delivered get "".
+func coalesceKey(event events.Event) string {
+	switch event.Type {
+	case events.EventTypePlaybackState, events.EventTypeQueueReordered, events.EventTypeSkipVote:
+		return string(event.Type)
+	case events.EventTypeSongVoted:
+		// Each vote carries the item's new total
+		var payload events.SongVotedPayload
+		if err := json.Unmarshal(event.Payload, &payload); err == nil {
+			return string(event.Type) + ":" + payload.QueueItemID
+		}
 	}
+	return ""
+}
 
-	trackName, ok := msg["track_name"].(string)
-	if !ok {
+// dropBannedMember disconnects a user who was just banned.
+func (h *Handler) dropBannedMember(roomID string, event events.Event) {
+	var payload events.MemberRoleChangedPayload
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
 		return
 	}
-
-	artist, ok := msg["artist"].(string)
-	if !ok {
+	if payload.Role != string(models.RoleBanned) {
 		return
 	}
 
-	// Publish song added event
-	payload := events.SongAddedPayload{
-		TrackID:   trackID,
-		TrackName: trackName,
-		Artist:    artist,
-	}
-
-	if err := h.events.PublishEvent(context.Background(), "song-additions", payload); err != nil {
-		log.Printf("Failed to publish song added event: %v", err)
-	}
+	// Each writer closes its socket, which ends that read loop
+	h.hub.closeUser(roomID, payload.UserID, websocket.ClosePolicyViolation, "banned")
 }
@@ -0,0 +1,171 @@
+package ws
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// hub holds the connections to each room on this instance. A user can be
+// connected more than once, from several tabs or devices.
+type hub struct {
+	mu sync.RWMutex
+	// Map of roomID -> map of connection ID -> *Conn
+	rooms map[string]map[string]*Conn
+	// Map of roomID -> map of userID -> the user's connections
+	users map[string]map[string]map[string]*Conn
+	// closed is set on shutdown, after which no client may register.
+	closed bool
+	// pumps counts running writePumps so shutdown can wait for them.
+	pumps sync.WaitGroup
+}
+
+func newHub() *hub {
+	return &hub{
+		rooms: make(map[string]map[string]*Conn),
+		users: make(map[string]map[string]map[string]*Conn),
+	}
+}
+
+// register adds c to its room and starts its writer. first reports whether
+// it is the user's only connection to the room. ok is false once the hub
+// is shutting down.
+func (h *hub) register(c *Conn) (first, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return false, false
+	}
+	if _, exists := h.rooms[c.roomID]; !exists {
+		h.rooms[c.roomID] = make(map[string]*Conn)
+		h.users[c.roomID] = make(map[string]map[string]*Conn)
+	}
+	h.rooms[c.roomID][c.id] = c
+
+	userConns, exists := h.users[c.roomID][c.userID]
+	if !exists {
+		userConns = make(map[string]*Conn)
+		h.users[c.roomID][c.userID] = userConns
+	}
+	userConns[c.id] = c
+
+	h.pumps.Add(1)
+	go func() {
+		defer h.pumps.Done()
+		c.writePump()
+	}()
+	return !exists, true
+}
+
+// unregister removes c from its room and disconnects it. last reports
+// whether the user has no other connection to the room left.
+func (h *hub) unregister(c *Conn) (last bool) {
+	h.mu.Lock()
+	if room, exists := h.rooms[c.roomID]; exists {
+		delete(room, c.id)
+
+		users := h.users[c.roomID]
+		if userConns, exists := users[c.userID]; exists {
+			delete(userConns, c.id)
+			if len(userConns) == 0 {
+				delete(users, c.userID)
+				last = true
+			}
+		}
+
+		if len(room) == 0 {
+			delete(h.rooms, c.roomID)
+			delete(h.users, c.roomID)
+		}
+	}
+	h.mu.Unlock()
+
+	c.Close(websocket.CloseNormalClosure, "")
+	return last
+}
+
+// broadcast wraps payload in an envelope of the given type and queues it
+// for every connection in the room, encoded once per protocol version in
+// use. key is passed on to Conn.Send.
+func (h *hub) broadcast(roomID, typ string, payload interface{}, key string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// A version that fails to encode stays nil, skipping its connections
+	encoded := make(map[int][]byte)
+	for _, c := range h.rooms[roomID] {
+		message, ok := encoded[c.version]
+		if !ok {
+			var err error
+			if message, err = encodeEnvelope(c.version, typ, "", payload); err != nil {
+				log.Printf("Failed to encode %s message for protocol version %d: %v", typ, c.version, err)
+			}
+			encoded[c.version] = message
+		}
+		if message == nil {
+			continue
+		}
+		c.Send(message, key)
+	}
+}
+
+// closeUser disconnects all of the user's connections to the room.
+func (h *hub) closeUser(roomID, userID string, code int, text string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, c := range h.users[roomID][userID] {
+		c.Close(code, text)
+	}
+}
+
+// closeRoom disconnects everyone in the room.
+func (h *hub) closeRoom(roomID string, code int, text string) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, c := range h.rooms[roomID] {
+		c.Close(code, text)
+	}
+}
+
+// roomIDs lists the rooms with at least one connection.
+func (h *hub) roomIDs() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	ids := make([]string, 0, len(h.rooms))
+	for roomID := range h.rooms {
+		ids = append(ids, roomID)
+	}
+	return ids
+}
+
+// conns returns the room's connections.
+func (h *hub) conns(roomID string) []*Conn {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	conns := make([]*Conn, 0, len(h.rooms[roomID]))
+	for _, c := range h.rooms[roomID] {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// shutdown disconnects everyone, refuses new connections and waits for the
+// writers to send their close frames.
+func (h *hub) shutdown() {
+	h.mu.Lock()
+	h.closed = true
+	for _, room := range h.rooms {
+		for _, c := range room {
+			c.Close(websocket.CloseGoingAway, "server shutting down")
+		}
+	}
+	h.mu.Unlock()
+
+	h.pumps.Wait()
+}
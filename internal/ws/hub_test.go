@@ -0,0 +1,256 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// connect registers a new connection of userID to roomID and returns the
+// client end of it.
+func connect(t *testing.T, h *hub, roomID, userID string, cfg Config) (*Conn, *websocket.Conn) {
+	t.Helper()
+
+	server, client := dial(t)
+	c := newConn(roomID, userID, server, cfg, ProtocolVersion)
+	if _, ok := h.register(c); !ok {
+		t.Fatal("register refused a connection before shutdown")
+	}
+	return c, client
+}
+
+// userCount counts the distinct users among the room's connections.
+func userCount(h *hub, roomID string) int {
+	users := make(map[string]bool)
+	for _, c := range h.conns(roomID) {
+		users[c.userID] = true
+	}
+	return len(users)
+}
+
+func TestRegisterTracksUsers(t *testing.T) {
+	h := newHub()
+	defer h.shutdown()
+	cfg := testConfig(8, PolicyDisconnect)
+
+	first := func(userID string) *Conn {
+		server, _ := dial(t)
+		c := newConn("room", userID, server, cfg, ProtocolVersion)
+		first, ok := h.register(c)
+		if !ok {
+			t.Fatal("register refused a connection before shutdown")
+		}
+		if !first {
+			t.Errorf("register(%s) first = false, want true", userID)
+		}
+		return c
+	}
+	alice1 := first("alice")
+	bob := first("bob")
+
+	server, _ := dial(t)
+	alice2 := newConn("room", "alice", server, cfg, ProtocolVersion)
+	if first, _ := h.register(alice2); first {
+		t.Error("second connection of alice reported as first")
+	}
+
+	if got := len(h.conns("room")); got != 3 {
+		t.Errorf("count = %d, want 3", got)
+	}
+	if got := userCount(h, "room"); got != 2 {
+		t.Errorf("userCount = %d, want 2", got)
+	}
+
+	if last := h.unregister(alice1); last {
+		t.Error("unregistering one of alice's two connections reported as last")
+	}
+	if last := h.unregister(alice2); !last {
+		t.Error("unregistering alice's last connection not reported as last")
+	}
+	if got := userCount(h, "room"); got != 1 {
+		t.Errorf("userCount after alice left = %d, want 1", got)
+	}
+
+	h.unregister(bob)
+	if got := len(h.conns("room")); got != 0 {
+		t.Errorf("count after everyone left = %d, want 0", got)
+	}
+	if ids := h.roomIDs(); len(ids) != 0 {
+		t.Errorf("roomIDs after everyone left = %v, want none", ids)
+	}
+}
+
+func TestCloseUserOnlyClosesThatUser(t *testing.T) {
+	h := newHub()
+	defer h.shutdown()
+	cfg := testConfig(8, PolicyDisconnect)
+
+	alice1, _ := connect(t, h, "room", "alice", cfg)
+	alice2, _ := connect(t, h, "room", "alice", cfg)
+	bob, _ := connect(t, h, "room", "bob", cfg)
+	elsewhere, _ := connect(t, h, "other", "alice", cfg)
+
+	h.closeUser("room", "alice", websocket.ClosePolicyViolation, "banned")
+
+	for name, c := range map[string]*Conn{"alice1": alice1, "alice2": alice2} {
+		if !isClosed(c) {
+			t.Errorf("%s still open after closeUser", name)
+		}
+	}
+	for name, c := range map[string]*Conn{"bob": bob, "alice in another room": elsewhere} {
+		if isClosed(c) {
+			t.Errorf("%s closed by closeUser", name)
+		}
+	}
+}
+
+func TestBroadcastConcurrentSenders(t *testing.T) {
+	const senders, perSender = 8, 50
+
+	h := newHub()
+	defer h.shutdown()
+	_, client := connect(t, h, "room", "user", testConfig(senders*perSender, PolicyDisconnect))
+
+	type seqPayload struct {
+		Sender int `json:"sender"`
+		Seq    int `json:"seq"`
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(sender int) {
+			defer wg.Done()
+			for seq := 0; seq < perSender; seq++ {
+				h.broadcast("room", TypeEvent, seqPayload{Sender: sender, Seq: seq}, "")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every message arrives, and each sender's in the order it sent them
+	next := make(map[int]int)
+	client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for n := 0; n < senders*perSender; n++ {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("read message %d: %v", n, err)
+		}
+
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("decode envelope: %v", err)
+		}
+		if env.Type != TypeEvent || env.V != ProtocolVersion {
+			t.Fatalf("envelope = %+v, want an event of version %d", env, ProtocolVersion)
+		}
+		var p seqPayload
+		if err := json.Unmarshal(env.Payload, &p); err != nil {
+			t.Fatalf("decode payload: %v", err)
+		}
+		if p.Seq != next[p.Sender] {
+			t.Fatalf("sender %d: got seq %d, want %d", p.Sender, p.Seq, next[p.Sender])
+		}
+		next[p.Sender]++
+	}
+}
+
+func TestBroadcastSkipsOtherRooms(t *testing.T) {
+	h := newHub()
+	defer h.shutdown()
+	cfg := testConfig(8, PolicyDisconnect)
+
+	_, inRoom := connect(t, h, "room", "alice", cfg)
+	_, outside := connect(t, h, "other", "bob", cfg)
+
+	h.broadcast("room", TypeUserJoined, PresencePayload{UserID: "carol"}, "")
+
+	inRoom.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := inRoom.ReadMessage(); err != nil {
+		t.Fatalf("member of the room did not get the broadcast: %v", err)
+	}
+
+	outside.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, data, err := outside.ReadMessage()
+	if err == nil {
+		t.Fatalf("connection in another room got %s", data)
+	}
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("read in another room = %v, want a timeout", err)
+	}
+}
+
+func TestShutdownWithSendsInFlight(t *testing.T) {
+	const clients = 4
+
+	h := newHub()
+	cfg := testConfig(16, PolicyDropOldest)
+
+	var conns []*Conn
+	closeErrs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		c, client := connect(t, h, "room", "user", cfg)
+		conns = append(conns, c)
+		go func() {
+			for {
+				if _, _, err := client.ReadMessage(); err != nil {
+					closeErrs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	stop := make(chan struct{})
+	var senders sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		senders.Add(1)
+		go func(c *Conn) {
+			defer senders.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				h.broadcast("room", TypeEvent, PresencePayload{UserID: "user"}, "")
+				c.Send([]byte(`{}`), "")
+			}
+		}(conns[i])
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		h.shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown did not return while sends were in flight")
+	}
+	close(stop)
+	senders.Wait()
+
+	for i := 0; i < clients; i++ {
+		select {
+		case err := <-closeErrs:
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("client read ended with %v, want a going away close frame", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("client was not disconnected by shutdown")
+		}
+	}
+
+	server, _ := dial(t)
+	if _, ok := h.register(newConn("room", "late", server, cfg, ProtocolVersion)); ok {
+		t.Error("register accepted a connection after shutdown")
+	}
+}
@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/music-queue-system/pkg/events"
+)
+
+// ProtocolVersion is the newest version of the message protocol described
+// in docs/websocket-protocol.md. Clients pick a version at connect time
+// with the Sec-WebSocket-Protocol header, offering "muzer.v<N>".
+const ProtocolVersion = 1
+
+// supportedVersions lists every protocol version the server speaks.
+var supportedVersions = []int{1}
+
+const subprotocolPrefix = "muzer.v"
+
+// Envelope wraps every message in either direction.
+type Envelope struct {
+	// V is the protocol version agreed at connect time.
+	V    int    `json:"v"`
+	Type string `json:"type"`
+	// RequestID is chosen by the client for each command and echoed in
+	// the ack or error that answers it.
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// Commands a client can send.
+const (
+	TypeVote        = "vote"
+	TypeRetractVote = "retract_vote"
+	TypeAddSong     = "add_song"
+	TypeSkipVote    = "skip_vote"
+)
+
+// Messages the server sends.
+const (
+	TypeWelcome    = "welcome"
+	TypeEvent      = "event"
+	TypeUserJoined = "user_joined"
+	TypeUserLeft   = "user_left"
+	TypeAck        = "ack"
+	TypeError      = "error"
+)
+
+// Error codes for malformed messages. Commands the room rejects carry the
+// room service's codes, such as "forbidden" or "queue_item_not_found".
+const (
+	CodeBadMessage         = "bad_message"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownType        = "unknown_type"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeInternal           = "internal_error"
+)
+
+// VotePayload is the payload of a vote command. Weight defaults to 1.
+type VotePayload struct {
+	QueueItemID string `json:"queue_item_id"`
+	Value       int    `json:"value"`
+	Weight      int    `json:"weight,omitempty"`
+}
+
+// RetractVotePayload is the payload of a retract_vote command.
+type RetractVotePayload struct {
+	QueueItemID string `json:"queue_item_id"`
+}
+
+// AddSongPayload is the payload of an add_song command. Only the track ID
+// is taken from the client; the room service looks up the rest.
+type AddSongPayload struct {
+	TrackID string `json:"track_id"`
+}
+
+// SkipVotePayload is the payload of a skip_vote command, which has no
+// fields.
+type SkipVotePayload struct{}
+
+// WelcomePayload is the first message on every connection.
+type WelcomePayload struct {
+	Version           int    `json:"version"`
+	SupportedVersions []int  `json:"supported_versions"`
+	ConnectionID      string `json:"connection_id"`
+	UserID            string `json:"user_id"`
+}
+
+// PresencePayload is the payload of user_joined and user_left, sent when a
+// user's first connection opens and their last one closes.
+type PresencePayload struct {
+	UserID string `json:"user_id"`
+}
+
+// ErrorPayload is the payload of an error reply.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// EventPayload is the payload of an event message: a room event as
+// published by the room service.
+type EventPayload = events.Event
+
+// negotiateVersion picks the protocol version from the subprotocols the
+// client offered, preferring the newest. Clients that offer none get
+// ProtocolVersion. ok is false if none of the offered versions is
+// supported.
+func negotiateVersion(offered []string) (version int, subprotocol string, ok bool) {
+	if len(offered) == 0 {
+		return ProtocolVersion, "", true
+	}
+
+	for _, proto := range offered {
+		if !strings.HasPrefix(proto, subprotocolPrefix) {
+			continue
+		}
+		v, ok := parseVersion(strings.TrimPrefix(proto, subprotocolPrefix))
+		if !ok {
+			continue
+		}
+		if isSupported(v) && v > version {
+			version, subprotocol = v, proto
+		}
+	}
+	return version, subprotocol, version != 0
+}
+
+// parseVersion accepts only the canonical form of a version, plain
+// decimal digits without a sign or leading zero, since the offered string
+// is echoed back as the agreed subprotocol.
+func parseVersion(s string) (int, bool) {
+	if s == "" || s[0] == '0' {
+		return 0, false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	v, err := strconv.Atoi(s)
+	return v, err == nil
+}
+
+func isSupported(version int) bool {
+	for _, v := range supportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeEnvelope marshals a server message. A nil payload is left out.
+func encodeEnvelope(version int, typ, requestID string, payload interface{}) ([]byte, error) {
+	env := Envelope{V: version, Type: typ, RequestID: requestID}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = raw
+	}
+	return json.Marshal(env)
+}
+
+// reply sends a server message that must not be coalesced away.
+func (c *Conn) reply(typ, requestID string, payload interface{}) {
+	message, err := encodeEnvelope(c.version, typ, requestID, payload)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", typ, err)
+		return
+	}
+	c.Send(message, "")
+}
+
+func (c *Conn) ack(requestID string, result interface{}) {
+	c.reply(TypeAck, requestID, result)
+}
+
+func (c *Conn) replyError(requestID, code, message string) {
+	c.reply(TypeError, requestID, ErrorPayload{Code: code, Message: message})
+}
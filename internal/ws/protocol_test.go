@@ -0,0 +1,75 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		offered         []string
+		wantVersion     int
+		wantSubprotocol string
+		wantOK          bool
+	}{
+		{name: "nothing offered gets the latest", wantVersion: ProtocolVersion, wantOK: true},
+		{name: "supported version", offered: []string{"muzer.v1"}, wantVersion: 1, wantSubprotocol: "muzer.v1", wantOK: true},
+		{name: "unsupported version", offered: []string{"muzer.v2"}, wantOK: false},
+		{name: "picks the supported one", offered: []string{"muzer.v9", "muzer.v1"}, wantVersion: 1, wantSubprotocol: "muzer.v1", wantOK: true},
+		{name: "ignores other protocols", offered: []string{"graphql-ws", "muzer.v1"}, wantVersion: 1, wantSubprotocol: "muzer.v1", wantOK: true},
+		{name: "only other protocols", offered: []string{"graphql-ws"}, wantOK: false},
+		{name: "malformed version", offered: []string{"muzer.vx", "muzer.v", "muzer.v1beta"}, wantOK: false},
+		{name: "version zero", offered: []string{"muzer.v0"}, wantOK: false},
+		{name: "signed version", offered: []string{"muzer.v+1", "muzer.v-1"}, wantOK: false},
+		{name: "leading zero", offered: []string{"muzer.v01", "muzer.v001"}, wantOK: false},
+		{name: "whitespace", offered: []string{"muzer.v 1", "muzer.v1 "}, wantOK: false},
+		{name: "out of range", offered: []string{"muzer.v99999999999999999999"}, wantOK: false},
+		{name: "canonical wins over non-canonical", offered: []string{"muzer.v01", "muzer.v1"}, wantVersion: 1, wantSubprotocol: "muzer.v1", wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, subprotocol, ok := negotiateVersion(tt.offered)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if version != tt.wantVersion || subprotocol != tt.wantSubprotocol {
+				t.Errorf("negotiateVersion() = %d, %q, want %d, %q", version, subprotocol, tt.wantVersion, tt.wantSubprotocol)
+			}
+		})
+	}
+}
+
+func TestEncodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		payload   interface{}
+		want      string
+	}{
+		{name: "ack without result", requestID: "1", want: `{"v":1,"type":"ack","request_id":"1"}`},
+		{name: "error", requestID: "2", payload: ErrorPayload{Code: CodeUnknownType, Message: "nope"}, want: `{"v":1,"type":"ack","request_id":"2","payload":{"code":"unknown_type","message":"nope"}}`},
+		{name: "broadcast", payload: PresencePayload{UserID: "u"}, want: `{"v":1,"type":"ack","payload":{"user_id":"u"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeEnvelope(1, TypeAck, tt.requestID, tt.payload)
+			if err != nil {
+				t.Fatalf("encodeEnvelope() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("encodeEnvelope() = %s, want %s", got, tt.want)
+			}
+
+			var env Envelope
+			if err := json.Unmarshal(got, &env); err != nil {
+				t.Errorf("envelope does not decode: %v", err)
+			}
+		})
+	}
+}
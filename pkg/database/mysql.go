@@ -1,12 +1,14 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 
 	"github.com/music-queue-system/pkg/models"
@@ -22,6 +24,8 @@ func NewMySQLDB(host, port, user, password, dbname string) (*MySQLDB, error) {
 
 	gormConfig := &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Info),
+		// Report unique index violations as gorm.ErrDuplicatedKey
+		TranslateError: true,
 	}
 
 	db, err := gorm.Open(mysql.Open(dsn), gormConfig)
@@ -44,18 +48,51 @@ func NewMySQLDB(host, port, user, password, dbname string) (*MySQLDB, error) {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
-	return &MySQLDB{DB: db}, nil
+	mysqlDB := &MySQLDB{DB: db}
+
+	// Backfill vote totals written before they were kept in sync
+	fixed, err := mysqlDB.ReconcileVoteCounts("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile vote counts: %w", err)
+	}
+	if fixed > 0 {
+		log.Printf("Reconciled vote totals for %d queue items", fixed)
+	}
+
+	return mysqlDB, nil
 }
 
 func autoMigrate(db *gorm.DB) error {
 	log.Println("Running database migrations...")
-	
-	return db.AutoMigrate(
+
+	if err := db.AutoMigrate(
 		&models.User{},
 		&models.Room{},
 		&models.QueueItem{},
 		&models.Vote{},
-	)
+		&models.RoomMember{},
+		&models.RoomInvite{},
+		&models.PlayHistory{},
+	); err != nil {
+		return err
+	}
+
+	return migrateRoomCodes(db)
+}
+
+// migrateRoomCodes moves code uniqueness from rooms.code, which kept codes
+// taken forever, to live_code, which only open rooms set.
+func migrateRoomCodes(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if migrator.HasIndex(&models.Room{}, "code") {
+		if err := migrator.DropIndex(&models.Room{}, "code"); err != nil {
+			return fmt.Errorf("failed to drop legacy room code index: %w", err)
+		}
+	}
+
+	return db.Exec(
+		"UPDATE rooms SET live_code = code, last_active_at = updated_at WHERE active AND live_code IS NULL",
+	).Error
 }
 
 // User operations
@@ -92,21 +129,191 @@ func (db *MySQLDB) GetRoomByID(id string) (*models.Room, error) {
 	return &room, nil
 }
 
+// GetRoomByCode finds the open room using code. Closed rooms are not
+// returned since their code may already belong to another room.
 func (db *MySQLDB) GetRoomByCode(code string) (*models.Room, error) {
 	var room models.Room
-	if err := db.First(&room, "code = ?", code).Error; err != nil {
+	if err := db.First(&room, "live_code = ?", code).Error; err != nil {
 		return nil, err
 	}
 	return &room, nil
 }
 
-func (db *MySQLDB) UpdateRoom(room *models.Room) error {
-	return db.Save(room).Error
+// UpdateRoomColumns writes only the named columns of room, leaving the
+// rest of the row to whoever else is updating it.
+func (db *MySQLDB) UpdateRoomColumns(room *models.Room, columns ...string) error {
+	return db.Model(&models.Room{}).
+		Where("id = ?", room.ID).
+		Select(columns).
+		Updates(room).Error
+}
+
+// TouchRoom records activity in an open room.
+func (db *MySQLDB) TouchRoom(roomID string, at time.Time) error {
+	return db.Model(&models.Room{}).
+		Where("id = ? AND active", roomID).
+		Update("last_active_at", at).Error
+}
+
+// CloseRoom deactivates an open room and releases its code, reporting
+// whether this call closed it.
+func (db *MySQLDB) CloseRoom(roomID string, at time.Time) (bool, error) {
+	result := db.Model(&models.Room{}).
+		Where("id = ? AND active", roomID).
+		Updates(map[string]interface{}{
+			"active":     false,
+			"live_code":  nil,
+			"closed_at":  at,
+			"updated_at": at,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ArchiveRoom marks a closed room archived, reporting whether this call
+// archived it.
+func (db *MySQLDB) ArchiveRoom(roomID string, at time.Time) (bool, error) {
+	result := db.Model(&models.Room{}).
+		Where("id = ? AND NOT active AND archived_at IS NULL", roomID).
+		Updates(map[string]interface{}{
+			"archived_at": at,
+			"updated_at":  at,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ReopenRoom reactivates a closed, unarchived room under code. It returns
+// gorm.ErrDuplicatedKey if an open room has taken the code meanwhile.
+func (db *MySQLDB) ReopenRoom(roomID, code string, at time.Time) (bool, error) {
+	result := db.Model(&models.Room{}).
+		Where("id = ? AND NOT active AND archived_at IS NULL", roomID).
+		Updates(map[string]interface{}{
+			"active":         true,
+			"code":           code,
+			"live_code":      code,
+			"opened_at":      at,
+			"closed_at":      nil,
+			"last_active_at": at,
+			"updated_at":     at,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ListIdleRooms returns the IDs of open rooms with no activity since cutoff.
+func (db *MySQLDB) ListIdleRooms(cutoff time.Time, limit int) ([]string, error) {
+	var roomIDs []string
+	err := db.Model(&models.Room{}).
+		Where("active AND last_active_at < ?", cutoff).
+		Order("last_active_at").
+		Limit(limit).
+		Pluck("id", &roomIDs).Error
+	return roomIDs, err
+}
+
+// Room member operations
+func (db *MySQLDB) GetRoomMember(roomID, userID string) (*models.RoomMember, error) {
+	var member models.RoomMember
+	if err := db.First(&member, "room_id = ? AND user_id = ?", roomID, userID).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// ListRoomMembers returns everyone who ever joined the room with their
+// contribution counts, optionally only those who have not left.
+func (db *MySQLDB) ListRoomMembers(roomID string, activeOnly bool) ([]*models.MemberSummary, error) {
+	query := db.Model(&models.RoomMember{}).
+		Select(`room_members.*,
+			(SELECT COUNT(*) FROM queue_items qi
+				WHERE qi.room_id = room_members.room_id AND qi.user_id = room_members.user_id) AS songs_added,
+			(SELECT COUNT(*) FROM votes v JOIN queue_items qi ON qi.id = v.queue_item_id
+				WHERE qi.room_id = room_members.room_id AND v.user_id = room_members.user_id) AS votes_cast`).
+		Where("room_members.room_id = ? AND room_members.joined_at IS NOT NULL", roomID)
+	if activeOnly {
+		query = query.Where("room_members.left_at IS NULL")
+	}
+
+	var members []*models.MemberSummary
+	if err := query.Order("room_members.joined_at ASC").Scan(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// JoinRoom records that the user joined, keeping any role they already have.
+func (db *MySQLDB) JoinRoom(member *models.RoomMember) error {
+	return db.Clauses(clause.OnConflict{
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"joined_at":  member.JoinedAt,
+			"left_at":    nil,
+			"updated_at": member.UpdatedAt,
+		}),
+	}).Create(member).Error
+}
+
+func (db *MySQLDB) LeaveRoom(roomID, userID string, leftAt time.Time) error {
+	return db.Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ? AND joined_at IS NOT NULL", roomID, userID).
+		Updates(map[string]interface{}{"left_at": leftAt, "updated_at": leftAt}).Error
+}
+
+// SetRoomMemberRole creates the membership or changes its role.
+func (db *MySQLDB) SetRoomMemberRole(member *models.RoomMember) error {
+	return db.Clauses(clause.OnConflict{
+		DoUpdates: clause.AssignmentColumns([]string{"role", "updated_at"}),
+	}).Create(member).Error
+}
+
+// Invite operations
+func (db *MySQLDB) CreateInvite(invite *models.RoomInvite) error {
+	return db.Create(invite).Error
+}
+
+func (db *MySQLDB) ListInvites(roomID string) ([]*models.RoomInvite, error) {
+	var invites []*models.RoomInvite
+	if err := db.Where("room_id = ?", roomID).Order("created_at DESC").Find(&invites).Error; err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+func (db *MySQLDB) RevokeInvite(roomID, inviteID string, revokedAt time.Time) (bool, error) {
+	result := db.Model(&models.RoomInvite{}).
+		Where("id = ? AND room_id = ? AND revoked_at IS NULL", inviteID, roomID).
+		Update("revoked_at", revokedAt)
+	return result.RowsAffected > 0, result.Error
+}
+
+// RedeemInvite uses up one use of the invite if it is still valid at now.
+// It reports false when the invite is revoked, expired or used up.
+func (db *MySQLDB) RedeemInvite(inviteID string, now time.Time) (bool, error) {
+	result := db.Model(&models.RoomInvite{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ? AND (max_uses = 0 OR uses < max_uses)", inviteID, now).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// QueueReader answers the questions a room's rules ask of its queue.
+type QueueReader interface {
+	HasPendingTrack(roomID, trackID string) (bool, error)
+	PlayedSince(roomID, trackID string, since time.Time) (bool, error)
+	CountPendingByUser(roomID, userID string) (int, error)
 }
 
 // Queue operations
-func (db *MySQLDB) AddToQueue(item *models.QueueItem) error {
-	return db.Create(item).Error
+
+// AddToQueue inserts item if check passes. check runs in the same
+// transaction while holding the room row lock, so what it reads through q
+// cannot change before the insert.
+func (db *MySQLDB) AddToQueue(item *models.QueueItem, check func(q QueueReader) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockRoom(tx, item.RoomID.String()); err != nil {
+			return err
+		}
+		if err := check(&MySQLDB{DB: tx}); err != nil {
+			return err
+		}
+		return tx.Create(item).Error
+	})
 }
 
 func (db *MySQLDB) GetQueue(roomID string) ([]*models.QueueItem, error) {
@@ -123,40 +330,422 @@ func (db *MySQLDB) UpdateQueueItem(item *models.QueueItem) error {
 	return db.Save(item).Error
 }
 
+func (db *MySQLDB) GetQueueItem(id string) (*models.QueueItem, error) {
+	var item models.QueueItem
+	if err := db.First(&item, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (db *MySQLDB) SetQueueItemDuration(id string, durationMs int) error {
+	return db.Model(&models.QueueItem{}).Where("id = ?", id).
+		Update("duration_ms", durationMs).Error
+}
+
+// RemoveQueueItem deletes an unplayed item and its votes, reporting
+// whether it was found.
+func (db *MySQLDB) RemoveQueueItem(roomID, id string) (bool, error) {
+	var removed bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND room_id = ? AND played = ?", id, roomID, false).Delete(&models.QueueItem{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		removed = true
+		return tx.Where("queue_item_id = ?", id).Delete(&models.Vote{}).Error
+	})
+	return removed, err
+}
+
+// PinQueueItem pins an item at position, moving the room's other pins at
+// or after it back one slot. A pin the item already had is released
+// first, so moving an item does not leave a gap.
+func (db *MySQLDB) PinQueueItem(roomID, id string, position int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		item, err := lockPinnedQueue(tx, roomID, id)
+		if err != nil {
+			return err
+		}
+		if item.Pinned {
+			if err := shiftPins(tx, roomID, item.Position+1, -1); err != nil {
+				return err
+			}
+		}
+		if err := shiftPins(tx, roomID, position, 1); err != nil {
+			return err
+		}
+		return tx.Model(item).Updates(map[string]interface{}{
+			"pinned":     true,
+			"position":   position,
+			"updated_at": time.Now(),
+		}).Error
+	})
+}
+
+// UnpinQueueItem returns an item to the ranking's order, closing the gap
+// its pin leaves.
+func (db *MySQLDB) UnpinQueueItem(roomID, id string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		item, err := lockPinnedQueue(tx, roomID, id)
+		if err != nil || !item.Pinned {
+			return err
+		}
+		if err := tx.Model(item).Updates(map[string]interface{}{
+			"pinned":     false,
+			"position":   0,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return shiftPins(tx, roomID, item.Position+1, -1)
+	})
+}
+
+// ShiftPins moves the room's pins at or after position by delta, for
+// when an item ahead of them leaves the queue.
+func (db *MySQLDB) ShiftPins(roomID string, from, delta int) error {
+	return shiftPins(db.DB, roomID, from, delta)
+}
+
+// lockPinnedQueue loads an unplayed item while holding the room row lock,
+// which serialises pin changes within a room.
+func lockPinnedQueue(tx *gorm.DB, roomID, id string) (*models.QueueItem, error) {
+	if err := lockRoom(tx, roomID); err != nil {
+		return nil, err
+	}
+
+	var item models.QueueItem
+	if err := tx.First(&item, "id = ? AND room_id = ? AND played = ?", id, roomID, false).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// lockRoom takes the room's row lock for the rest of the transaction.
+func lockRoom(tx *gorm.DB, roomID string) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").First(&models.Room{}, "id = ?", roomID).Error
+}
+
+func shiftPins(tx *gorm.DB, roomID string, from, delta int) error {
+	return tx.Model(&models.QueueItem{}).
+		Where("room_id = ? AND pinned AND played = ? AND position >= ?", roomID, false, from).
+		UpdateColumn("position", gorm.Expr("position + ?", delta)).Error
+}
+
+// HasPendingTrack reports whether the track is queued and unplayed in the
+// room.
+func (db *MySQLDB) HasPendingTrack(roomID, trackID string) (bool, error) {
+	var count int64
+	err := db.Model(&models.QueueItem{}).
+		Where("room_id = ? AND track_id = ? AND played = ?", roomID, trackID, false).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// PlayedSince reports whether the track was played in the room after since.
+func (db *MySQLDB) PlayedSince(roomID, trackID string, since time.Time) (bool, error) {
+	var count int64
+	err := db.Model(&models.QueueItem{}).
+		Where("room_id = ? AND track_id = ? AND played = ? AND played_at > ?", roomID, trackID, true, since).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CountPendingByUser counts the user's unplayed songs in the room.
+func (db *MySQLDB) CountPendingByUser(roomID, userID string) (int, error) {
+	var count int64
+	err := db.Model(&models.QueueItem{}).
+		Where("room_id = ? AND user_id = ? AND played = ?", roomID, userID, false).
+		Count(&count).Error
+	return int(count), err
+}
+
+// TakeQueueHead takes the item at the front of the room's queue off it:
+// the item loses any pin, is marked played at playedAt when that is set,
+// and the pins behind it move up into the slot it leaves.
+func (db *MySQLDB) TakeQueueHead(roomID, id string, playedAt *time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockRoom(tx, roomID); err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"pinned": false, "position": 0}
+		if playedAt != nil {
+			updates["played"] = true
+			updates["played_at"] = *playedAt
+		}
+		if err := tx.Model(&models.QueueItem{}).
+			Where("id = ? AND room_id = ?", id, roomID).
+			Updates(updates).Error; err != nil {
+			return err
+		}
+		return shiftPins(tx, roomID, 1, -1)
+	})
+}
+
+// FinishPlay marks the item played and records the play in one
+// transaction. The caller sets the room, item, timing and Skipped; the
+// track details and final vote tally are copied from the item.
+func (db *MySQLDB) FinishPlay(play *models.PlayHistory) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var item models.QueueItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&item, "id = ?", play.QueueItemID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&item).
+			Updates(map[string]interface{}{"played": true, "played_at": play.EndedAt}).Error; err != nil {
+			return err
+		}
+
+		var tally models.VoteTally
+		if err := tx.Model(&models.Vote{}).
+			Select("COALESCE(SUM(CASE WHEN value > 0 THEN 1 ELSE 0 END), 0) AS up, COALESCE(SUM(CASE WHEN value < 0 THEN 1 ELSE 0 END), 0) AS down").
+			Where("queue_item_id = ?", item.ID).
+			Scan(&tally).Error; err != nil {
+			return err
+		}
+		play.AddedBy = item.UserID
+		play.TrackID = item.TrackID
+		play.TrackName = item.TrackName
+		play.Artist = item.Artist
+		play.Album = item.Album
+		play.ArtworkURL = item.ArtworkURL
+		play.Score = item.Votes
+		play.UpVotes = tally.Up
+		play.DownVotes = tally.Down
+		return tx.Create(play).Error
+	})
+}
+
+// ListPlayHistory returns a page of the room's plays, newest first, and
+// the number of plays in total.
+func (db *MySQLDB) ListPlayHistory(roomID string, limit, offset int) ([]*models.PlayHistory, int64, error) {
+	query := db.Model(&models.PlayHistory{}).Where("room_id = ?", roomID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var plays []*models.PlayHistory
+	if err := query.Order("started_at DESC").Limit(limit).Offset(offset).Find(&plays).Error; err != nil {
+		return nil, 0, err
+	}
+	return plays, total, nil
+}
+
+// ListPlaysSince returns the room's plays started since, oldest first.
+func (db *MySQLDB) ListPlaysSince(roomID string, since time.Time) ([]*models.PlayHistory, error) {
+	var plays []*models.PlayHistory
+	if err := db.Where("room_id = ? AND started_at >= ?", roomID, since).
+		Order("started_at").
+		Find(&plays).Error; err != nil {
+		return nil, err
+	}
+	return plays, nil
+}
+
+// TopPlays returns up to limit of the room's plays started since, ordered
+// by order, e.g. "score DESC".
+func (db *MySQLDB) TopPlays(roomID string, since time.Time, order string, limit int) ([]*models.PlayHistory, error) {
+	var plays []*models.PlayHistory
+	if err := db.Where("room_id = ? AND started_at >= ?", roomID, since).
+		Order(order).Order("started_at DESC").
+		Limit(limit).
+		Find(&plays).Error; err != nil {
+		return nil, err
+	}
+	return plays, nil
+}
+
+// TopContributors ranks who added the songs played since, by their songs'
+// total score and then by plays.
+func (db *MySQLDB) TopContributors(roomID string, since time.Time, limit int) ([]*models.ContributorStat, error) {
+	var stats []*models.ContributorStat
+	if err := db.Model(&models.PlayHistory{}).
+		Select("play_histories.added_by AS user_id, users.display_name, COUNT(*) AS plays, SUM(play_histories.score) AS score").
+		Joins("LEFT JOIN users ON users.id = play_histories.added_by").
+		Where("play_histories.room_id = ? AND play_histories.started_at >= ?", roomID, since).
+		Group("play_histories.added_by, users.display_name").
+		Order("score DESC, plays DESC").
+		Limit(limit).
+		Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// PlayTotals counts the plays started since and how long they played.
+func (db *MySQLDB) PlayTotals(roomID string, since time.Time) (plays int, playedMs int64, err error) {
+	var totals struct {
+		Plays    int
+		PlayedMs int64
+	}
+	err = db.Model(&models.PlayHistory{}).
+		Select("COUNT(*) AS plays, COALESCE(SUM(played_ms), 0) AS played_ms").
+		Where("room_id = ? AND started_at >= ?", roomID, since).
+		Scan(&totals).Error
+	return totals.Plays, totals.PlayedMs, err
+}
+
 // Vote operations
-func (db *MySQLDB) CreateOrUpdateVote(vote *models.Vote) error {
-	var existing models.Vote
-	result := db.Where("queue_item_id = ? AND user_id = ?", vote.QueueItemID, vote.UserID).First(&existing)
-	
-	if result.Error == gorm.ErrRecordNotFound {
-		return db.Create(vote).Error
+
+// CreateOrUpdateVote upserts the user's vote and applies the change to the
+// item's denormalized vote total in the same transaction. It returns the
+// item's new total and the vote it replaced, if any.
+func (db *MySQLDB) CreateOrUpdateVote(vote *models.Vote) (int, *models.Vote, error) {
+	var total int
+	var previous *models.Vote
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the item so concurrent votes on it apply one after another
+		var item models.QueueItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&item, "id = ?", vote.QueueItemID).Error; err != nil {
+			return err
+		}
+
+		delta := vote.Value * vote.Weight
+		var existing models.Vote
+		err := tx.Where("queue_item_id = ? AND user_id = ?", vote.QueueItemID, vote.UserID).First(&existing).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := tx.Create(vote).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			replaced := existing
+			previous = &replaced
+			delta -= existing.Value * existing.Weight
+			existing.Value = vote.Value
+			existing.Weight = vote.Weight
+			if err := tx.Save(&existing).Error; err != nil {
+				return err
+			}
+		}
+
+		total = item.Votes + delta
+		if delta == 0 {
+			return nil
+		}
+		return tx.Model(&models.QueueItem{}).Where("id = ?", item.ID).
+			UpdateColumn("votes", gorm.Expr("votes + ?", delta)).Error
+	})
+	return total, previous, err
+}
+
+// GetVote returns the user's vote on an item, or nil if they have not
+// voted.
+func (db *MySQLDB) GetVote(queueItemID, userID string) (*models.Vote, error) {
+	var vote models.Vote
+	err := db.Where("queue_item_id = ? AND user_id = ?", queueItemID, userID).First(&vote).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
+	return &vote, nil
+}
+
+// DeleteVote removes the user's vote on an unplayed item and takes it off
+// the item's total. It returns the new total and the deleted vote, nil if
+// the user had not voted, and gorm.ErrRecordNotFound once the item has
+// been played.
+func (db *MySQLDB) DeleteVote(queueItemID, userID string) (int, *models.Vote, error) {
+	var total int
+	var deleted *models.Vote
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var item models.QueueItem
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&item, "id = ? AND played = ?", queueItemID, false).Error; err != nil {
+			return err
+		}
+
+		total = item.Votes
+		var existing models.Vote
+		err := tx.Where("queue_item_id = ? AND user_id = ?", queueItemID, userID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-	existing.Value = vote.Value
-	return db.Save(&existing).Error
+		if err := tx.Delete(&existing).Error; err != nil {
+			return err
+		}
+		deleted = &existing
+		total -= existing.Value * existing.Weight
+		return tx.Model(&models.QueueItem{}).Where("id = ?", item.ID).
+			UpdateColumn("votes", gorm.Expr("votes - ?", existing.Value*existing.Weight)).Error
+	})
+	return total, deleted, err
 }
 
-func (db *MySQLDB) GetVotesForItem(queueItemID string) (int, error) {
-	var sum struct {
-		Total int
+// GetUserVotes returns the user's votes on the room's unplayed items.
+func (db *MySQLDB) GetUserVotes(roomID, userID string) ([]*models.Vote, error) {
+	var votes []*models.Vote
+	if err := db.Joins("JOIN queue_items ON queue_items.id = votes.queue_item_id").
+		Where("queue_items.room_id = ? AND queue_items.played = ? AND votes.user_id = ?", roomID, false, userID).
+		Find(&votes).Error; err != nil {
+		return nil, err
 	}
-	
+	return votes, nil
+}
+
+// GetVoters lists who voted on an item, newest first.
+func (db *MySQLDB) GetVoters(queueItemID string) ([]*models.Voter, error) {
+	var voters []*models.Voter
 	if err := db.Model(&models.Vote{}).
-		Select("COALESCE(SUM(value), 0) as total").
-		Where("queue_item_id = ?", queueItemID).
-		Scan(&sum).Error; err != nil {
-		return 0, err
+		Select("votes.user_id, users.display_name, votes.value, votes.weight, votes.created_at").
+		Joins("LEFT JOIN users ON users.id = votes.user_id").
+		Where("votes.queue_item_id = ?", queueItemID).
+		Order("votes.created_at DESC").
+		Scan(&voters).Error; err != nil {
+		return nil, err
+	}
+	return voters, nil
+}
+
+// ReconcileVoteCounts rebuilds queue_items.votes from the votes table for
+// one room, or for every room when roomID is empty. It returns the number
+// of items whose total was wrong.
+func (db *MySQLDB) ReconcileVoteCounts(roomID string) (int64, error) {
+	query := `UPDATE queue_items qi
+		LEFT JOIN (SELECT queue_item_id, SUM(value * weight) AS total FROM votes GROUP BY queue_item_id) v
+			ON v.queue_item_id = qi.id
+		SET qi.votes = COALESCE(v.total, 0)
+		WHERE qi.votes <> COALESCE(v.total, 0)`
+	var args []interface{}
+	if roomID != "" {
+		query += " AND qi.room_id = ?"
+		args = append(args, roomID)
 	}
 
-	return sum.Total, nil
+	result := db.Exec(query, args...)
+	return result.RowsAffected, result.Error
 }
 
-func (db *MySQLDB) GetNextSong(roomID string) (*models.QueueItem, error) {
-	var item models.QueueItem
-	if err := db.Where("room_id = ? AND played = ?", roomID, false).
-		Order("votes DESC, created_at ASC").
-		First(&item).Error; err != nil {
+// GetVoteTallies returns up and down vote counts, plain and weighted, for
+// the room's unplayed items. Items without votes are omitted.
+func (db *MySQLDB) GetVoteTallies(roomID string) ([]models.VoteTally, error) {
+	var tallies []models.VoteTally
+	if err := db.Model(&models.Vote{}).
+		Select("votes.queue_item_id, "+
+			"SUM(CASE WHEN votes.value > 0 THEN 1 ELSE 0 END) AS up, "+
+			"SUM(CASE WHEN votes.value < 0 THEN 1 ELSE 0 END) AS down, "+
+			"SUM(CASE WHEN votes.value > 0 THEN votes.weight ELSE 0 END) AS weighted_up, "+
+			"SUM(CASE WHEN votes.value < 0 THEN votes.weight ELSE 0 END) AS weighted_down").
+		Joins("JOIN queue_items ON queue_items.id = votes.queue_item_id").
+		Where("queue_items.room_id = ? AND queue_items.played = ?", roomID, false).
+		Group("votes.queue_item_id").
+		Scan(&tallies).Error; err != nil {
 		return nil, err
 	}
-	return &item, nil
+	return tallies, nil
 }
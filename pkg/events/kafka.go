@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,20 +14,37 @@ import (
 type EventType string
 
 const (
-	EventTypeSongAdded     EventType = "song_added"
-	EventTypeSongVoted     EventType = "song_voted"
-	EventTypeSongStarted   EventType = "song_started"
-	EventTypeSongCompleted EventType = "song_completed"
-	EventTypeUserJoined    EventType = "user_joined"
-	EventTypeUserLeft      EventType = "user_left"
+	EventTypeSongAdded      EventType = "song_added"
+	EventTypeSongVoted      EventType = "song_voted"
+	EventTypeSongStarted    EventType = "song_started"
+	EventTypeSongCompleted  EventType = "song_completed"
+	EventTypeSongRemoved    EventType = "song_removed"
+	EventTypeQueueReordered EventType = "queue_reordered"
+	EventTypeUserJoined     EventType = "user_joined"
+	EventTypeUserLeft       EventType = "user_left"
+
+	EventTypePlaybackPaused  EventType = "playback_paused"
+	EventTypePlaybackResumed EventType = "playback_resumed"
+	EventTypePlaybackEnded   EventType = "playback_ended"
+	EventTypeSkipVote        EventType = "skip_vote"
+
+	EventTypeMemberRoleChanged EventType = "member_role_changed"
+	EventTypeMemberJoined      EventType = "member_joined"
+	EventTypeMemberLeft        EventType = "member_left"
+
+	EventTypeRoomClosed   EventType = "room_closed"
+	EventTypeRoomReopened EventType = "room_reopened"
+	// EventTypePlaybackState is sent directly to a client when it connects
+	// so late joiners see the current track.
+	EventTypePlaybackState EventType = "playback_state"
 )
 
 type Event struct {
-	Type      EventType         `json:"type"`
-	RoomID    string           `json:"room_id"`
-	UserID    string           `json:"user_id"`
-	Timestamp time.Time        `json:"timestamp"`
-	Payload   json.RawMessage  `json:"payload"`
+	Type      EventType       `json:"type"`
+	RoomID    string          `json:"room_id"`
+	UserID    string          `json:"user_id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
 }
 
 type KafkaClient struct {
@@ -36,9 +54,11 @@ type KafkaClient struct {
 
 func NewKafkaClient(brokers []string, topic string, groupID string) *KafkaClient {
 	writer := &kafka.Writer{
-		Addr:     kafka.TCP(brokers...),
-		Topic:    topic,
-		Balancer: &kafka.LeastBytes{},
+		Addr:  kafka.TCP(brokers...),
+		Topic: topic,
+		// Hash the key so each room's events share a partition and
+		// stay in order
+		Balancer: &kafka.Hash{},
 	}
 
 	reader := kafka.NewReader(kafka.ReaderConfig{
@@ -72,36 +92,61 @@ func (k *KafkaClient) PublishEvent(ctx context.Context, topic string, payload in
 	return nil
 }
 
-func (k *KafkaClient) PublishVoteUpdate(ctx context.Context, roomID, trackID string, totalVotes int) error {
-	payload := VoteUpdatePayload{
-		RoomID:     roomID,
-		TrackID:    trackID,
-		TotalVotes: totalVotes,
-		Timestamp:  time.Now(),
+// PublishRoomEvent wraps payload in an Event addressed to a room. Messages
+// are keyed by room so a room's events stay in order.
+func (k *KafkaClient) PublishRoomEvent(ctx context.Context, eventType EventType, roomID, userID string, payload interface{}) error {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	event := Event{
+		Type:      eventType,
+		RoomID:    roomID,
+		UserID:    userID,
+		Timestamp: time.Now(),
+		Payload:   payloadJSON,
+	}
+
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	msg := kafka.Message{
+		Key:   []byte(roomID),
+		Value: eventJSON,
+	}
+
+	if err := k.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
 	}
 
-	return k.PublishEvent(ctx, "vote-updates", payload)
+	return nil
 }
 
+// ConsumeEvents passes each event read to handler until ctx is done or
+// the reader fails. Messages that are not events, and events the handler
+// fails on, are logged and skipped so one bad message cannot stop the
+// stream.
 func (k *KafkaClient) ConsumeEvents(ctx context.Context, handler func(Event) error) error {
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			msg, err := k.reader.ReadMessage(ctx)
-			if err != nil {
-				return fmt.Errorf("failed to read message: %w", err)
+		msg, err := k.reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
+			return fmt.Errorf("failed to read message: %w", err)
+		}
 
-			var event Event
-			if err := json.Unmarshal(msg.Value, &event); err != nil {
-				return fmt.Errorf("failed to unmarshal event: %w", err)
-			}
+		var event Event
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			log.Printf("Skipping malformed event at offset %d: %v", msg.Offset, err)
+			continue
+		}
 
-			if err := handler(event); err != nil {
-				return fmt.Errorf("failed to handle event: %w", err)
-			}
+		if err := handler(event); err != nil {
+			log.Printf("Failed to handle %s event for room %s: %v", event.Type, event.RoomID, err)
 		}
 	}
 }
@@ -118,28 +163,77 @@ func (k *KafkaClient) Close() error {
 
 // Event payload types
 type SongAddedPayload struct {
-	TrackID   string `json:"track_id"`
-	TrackName string `json:"track_name"`
-	Artist    string `json:"artist"`
+	QueueItemID string `json:"queue_item_id"`
+	TrackID     string `json:"track_id"`
+	TrackName   string `json:"track_name"`
+	Artist      string `json:"artist"`
 }
 
-type SongVotedPayload struct {
-	TrackID string `json:"track_id"`
-	UserID  string `json:"user_id"`
-	Value   int    `json:"value"`
+type SongRemovedPayload struct {
+	QueueItemID string `json:"queue_item_id"`
+	TrackID     string `json:"track_id"`
+	RemovedBy   string `json:"removed_by"`
+}
+
+// QueueReorderedPayload describes a pin change and carries the resulting
+// queue order as queue item IDs.
+type QueueReorderedPayload struct {
+	QueueItemID string   `json:"queue_item_id"`
+	Pinned      bool     `json:"pinned"`
+	Position    int      `json:"position"`
+	Order       []string `json:"order"`
 }
 
-type VoteUpdatePayload struct {
-	RoomID     string    `json:"room_id"`
-	TrackID    string    `json:"track_id"`
-	TotalVotes int       `json:"total_votes"`
-	Timestamp  time.Time `json:"timestamp"`
+// SongVotedPayload reports a vote on a queue item. Value is 0 when the
+// user took their vote back. UserID is empty in rooms that keep votes
+// anonymous.
+type SongVotedPayload struct {
+	QueueItemID string `json:"queue_item_id"`
+	TrackID     string `json:"track_id"`
+	UserID      string `json:"user_id,omitempty"`
+	Value       int    `json:"value"`
+	Weight      int    `json:"weight,omitempty"`
+	TotalVotes  int    `json:"total_votes"`
 }
 
 type SongStartedPayload struct {
-	TrackID   string `json:"track_id"`
-	TrackName string `json:"track_name"`
-	Artist    string `json:"artist"`
+	QueueItemID string    `json:"queue_item_id"`
+	TrackID     string    `json:"track_id"`
+	TrackName   string    `json:"track_name"`
+	Artist      string    `json:"artist"`
+	DurationMs  int       `json:"duration_ms"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+type SongCompletedPayload struct {
+	QueueItemID string `json:"queue_item_id"`
+	TrackID     string `json:"track_id"`
+	PlayedMs    int    `json:"played_ms"`
+	Skipped     bool   `json:"skipped"`
+}
+
+type PlaybackPayload struct {
+	QueueItemID string `json:"queue_item_id"`
+	PositionMs  int    `json:"position_ms"`
+}
+
+// SkipVotePayload reports progress towards skipping the playing song.
+type SkipVotePayload struct {
+	QueueItemID string `json:"queue_item_id"`
+	Votes       int    `json:"votes"`
+	Needed      int    `json:"needed"`
+	Skipped     bool   `json:"skipped"`
+}
+
+type MemberRoleChangedPayload struct {
+	UserID       string `json:"user_id"`
+	Role         string `json:"role"`
+	PreviousRole string `json:"previous_role"`
+}
+
+type RoomClosedPayload struct {
+	// Reason is "host" or "idle".
+	Reason string `json:"reason"`
 }
 
 type UserJoinedPayload struct {
@@ -0,0 +1,58 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// inviteAudience keeps invite tokens from being accepted as login tokens
+// and the other way round.
+const inviteAudience = "room-invite"
+
+type InviteClaims struct {
+	InviteID string `json:"invite_id"`
+	RoomID   string `json:"room_id"`
+	jwt.RegisteredClaims
+}
+
+func GenerateInviteToken(inviteID, roomID string, expiresAt time.Time) (string, error) {
+	claims := &InviteClaims{
+		InviteID: inviteID,
+		RoomID:   roomID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{inviteAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString(jwtSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign invite token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+func ValidateInviteToken(tokenString string) (*InviteClaims, error) {
+	claims := &InviteClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecret, nil
+	}, jwt.WithAudience(inviteAudience))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse invite token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid invite token")
+	}
+
+	return claims, nil
+}
@@ -59,5 +59,10 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	// Login tokens carry no audience; anything else is e.g. an invite
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("invalid token audience")
+	}
+
 	return claims, nil
 }
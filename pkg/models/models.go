@@ -16,27 +16,216 @@ type User struct {
 }
 
 type Room struct {
-	ID        uuid.UUID `json:"id" gorm:"primaryKey"`
-	Code      string    `json:"code" gorm:"unique"`
-	HostID    uuid.UUID `json:"host_id"`
-	Name      string    `json:"name"`
-	Active    bool      `json:"active"`
+	ID   uuid.UUID `json:"id" gorm:"primaryKey"`
+	Code string    `json:"code" gorm:"index;size:16"`
+	// LiveCode is Code while the room is open and NULL once it closes. Its
+	// unique index only covers open rooms, so closed rooms free their code
+	// for reuse.
+	LiveCode *string   `json:"-" gorm:"uniqueIndex;size:16"`
+	HostID   uuid.UUID `json:"host_id"`
+	Name     string    `json:"name"`
+	// Active is false once the room is closed or archived.
+	Active bool `json:"active"`
+	// OpenedAt is when the room was last reopened, nil if it never closed;
+	// the current session starts then or at CreatedAt.
+	OpenedAt   *time.Time `json:"opened_at,omitempty"`
+	ClosedAt   *time.Time `json:"closed_at,omitempty"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	// LastActiveAt is bumped, at most once a minute, by queue, vote and
+	// playback activity; idle rooms are closed automatically.
+	LastActiveAt time.Time `json:"last_active_at"`
+	Ranking      string    `json:"ranking" gorm:"default:votes"`
+	// Private rooms can only be joined with the passcode or an invite.
+	Private      bool   `json:"private"`
+	PasscodeHash string `json:"-"`
+	// DefaultRole is the role of users without an explicit membership.
+	DefaultRole RoomRole `json:"default_role" gorm:"default:dj"`
+	// RotationWeights gives contributors extra turns in the DJ rotation,
+	// keyed by user ID or by role name.
+	RotationWeights map[string]int `json:"rotation_weights,omitempty" gorm:"serializer:json;type:text"`
+	// SkipVoteMode says whether SkipVoteThreshold is a percentage of the
+	// connected users or an absolute number of votes.
+	SkipVoteMode      string `json:"skip_vote_mode" gorm:"default:percent"`
+	SkipVoteThreshold int    `json:"skip_vote_threshold" gorm:"default:50"`
+	// ShowVoters lets members see who voted on a song rather than only
+	// the counts.
+	ShowVoters bool `json:"show_voters"`
+	// VoteMode is one of the VoteMode* constants. In credit rooms each
+	// member gets VoteCreditsPerHour credits an hour, banking at most one
+	// hour's worth.
+	VoteMode           string `json:"vote_mode" gorm:"default:unlimited"`
+	VoteCreditsPerHour int    `json:"vote_credits_per_hour" gorm:"default:10"`
+	// Rules limit what can be added to the queue.
+	Rules     RoomRules `json:"rules" gorm:"serializer:json;type:text"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// RoomRules are the host's content rules for a room's queue. The zero
+// value only rejects duplicates of songs that are still queued.
+type RoomRules struct {
+	AllowDuplicates bool `json:"allow_duplicates"`
+	// ReplayWindowHours rejects songs played in the room within that many
+	// hours. 0 turns the check off.
+	ReplayWindowHours int `json:"replay_window_hours"`
+	// MaxDurationSeconds caps track length. 0 means no limit.
+	MaxDurationSeconds int  `json:"max_duration_seconds"`
+	BlockExplicit      bool `json:"block_explicit"`
+	// BannedArtists and BannedTracks hold Spotify IDs.
+	BannedArtists []string `json:"banned_artists,omitempty"`
+	BannedTracks  []string `json:"banned_tracks,omitempty"`
+	// MaxPendingPerUser caps each user's songs waiting in the queue. 0
+	// means no limit.
+	MaxPendingPerUser int `json:"max_pending_per_user"`
+}
+
+// Skip vote threshold modes.
+const (
+	SkipVotePercent = "percent"
+	SkipVoteCount   = "count"
+)
+
+// Voting modes. Unlimited rooms allow one ±1 vote per song; credit rooms
+// charge for up-votes from a budget that refills every hour.
+const (
+	VoteModeUnlimited = "unlimited"
+	VoteModeCredits   = "credits"
+)
+
+// Queue ranking strategies a room can choose from.
+const (
+	RankingVotes      = "votes"
+	RankingWilson     = "wilson"
+	RankingHot        = "hot"
+	RankingRoundRobin = "round_robin"
+	RankingFIFO       = "fifo"
+	RankingRotation   = "rotation"
+)
+
+type RoomRole string
+
+const (
+	RoleHost     RoomRole = "host"
+	RoleCoHost   RoomRole = "cohost"
+	RoleDJ       RoomRole = "dj"
+	RoleListener RoomRole = "listener"
+	RoleBanned   RoomRole = "banned"
+)
+
+// RoomMember records a user's membership and role in a room. A user is a
+// member while JoinedAt is set and LeftAt is not; a row can also exist only
+// to carry a role, e.g. a ban. An empty Role means the room's DefaultRole,
+// and the host's role is implied by Room.HostID.
+type RoomMember struct {
+	RoomID    uuid.UUID  `json:"room_id" gorm:"primaryKey"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"primaryKey"`
+	Role      RoomRole   `json:"role"`
+	JoinedAt  *time.Time `json:"joined_at,omitempty"`
+	LeftAt    *time.Time `json:"left_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// IsMember reports whether the user has joined and not left.
+func (m *RoomMember) IsMember() bool {
+	return m.JoinedAt != nil && m.LeftAt == nil
+}
+
+// RoomInvite is a revocable invite link. The link carries a signed token
+// naming the invite; the limits are enforced here. MaxUses 0 means unlimited.
+type RoomInvite struct {
+	ID        uuid.UUID  `json:"id" gorm:"primaryKey"`
+	RoomID    uuid.UUID  `json:"room_id" gorm:"index"`
+	CreatedBy uuid.UUID  `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MemberSummary is a room member with their activity in the room.
+type MemberSummary struct {
+	RoomMember
+	SongsAdded int `json:"songs_added"`
+	VotesCast  int `json:"votes_cast"`
+}
+
 type QueueItem struct {
 	ID        uuid.UUID `json:"id" gorm:"primaryKey"`
 	RoomID    uuid.UUID `json:"room_id"`
 	UserID    uuid.UUID `json:"user_id"`
 	TrackID   string    `json:"track_id"`
 	TrackName string    `json:"track_name"`
-	Artist    string    `json:"artist"`
-	Votes     int       `json:"votes"`
-	Position  int       `json:"position"`
-	Played    bool      `json:"played"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	// Artist is the artists' names joined for display; Artists has each
+	// one with its Spotify ID.
+	Artist     string        `json:"artist"`
+	Artists    []TrackArtist `json:"artists" gorm:"serializer:json;type:text"`
+	Album      string        `json:"album"`
+	ArtworkURL string        `json:"artwork_url"`
+	Explicit   bool          `json:"explicit"`
+	ISRC       string        `json:"isrc"`
+	Votes      int           `json:"votes"`
+	// Pinned items ignore the room's ranking and play at Position, counted
+	// from 0 for the next song to play. Position means nothing unless the
+	// item is pinned.
+	Pinned   bool `json:"pinned"`
+	Position int  `json:"position"`
+	Played   bool `json:"played"`
+	// DurationMs is filled from Spotify when the song is queued, or for
+	// older items when it starts playing.
+	DurationMs int        `json:"duration_ms"`
+	PlayedAt   *time.Time `json:"played_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// PlayHistory records one play of a queue item, written when it stops
+// playing. Score and the vote counts are the item's final tally.
+type PlayHistory struct {
+	ID          uuid.UUID `json:"id" gorm:"primaryKey"`
+	RoomID      uuid.UUID `json:"room_id" gorm:"index:idx_play_history_room_started,priority:1"`
+	QueueItemID uuid.UUID `json:"queue_item_id" gorm:"uniqueIndex"`
+	AddedBy     uuid.UUID `json:"added_by"`
+	TrackID     string    `json:"track_id"`
+	TrackName   string    `json:"track_name"`
+	Artist      string    `json:"artist"`
+	Album       string    `json:"album"`
+	ArtworkURL  string    `json:"artwork_url"`
+	DurationMs  int       `json:"duration_ms"`
+	// PlayedMs is how much of the song played before it ended or was
+	// skipped.
+	PlayedMs  int       `json:"played_ms"`
+	Skipped   bool      `json:"skipped"`
+	Score     int       `json:"score"`
+	UpVotes   int       `json:"up_votes"`
+	DownVotes int       `json:"down_votes"`
+	StartedAt time.Time `json:"started_at" gorm:"index:idx_play_history_room_started,priority:2"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// ContributorStat sums up one user's songs in a session.
+type ContributorStat struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DisplayName string    `json:"display_name"`
+	Plays       int       `json:"plays"`
+	Score       int       `json:"score"`
+}
+
+type TrackArtist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// VoteTally counts the up and down votes on a queue item. Up and Down
+// count voters; WeightedUp and WeightedDown count a super-vote as its
+// weight.
+type VoteTally struct {
+	QueueItemID  uuid.UUID `json:"queue_item_id"`
+	Up           int       `json:"up"`
+	Down         int       `json:"down"`
+	WeightedUp   int       `json:"weighted_up"`
+	WeightedDown int       `json:"weighted_down"`
 }
 
 type Vote struct {
@@ -44,5 +233,60 @@ type Vote struct {
 	QueueItemID uuid.UUID `json:"queue_item_id"`
 	UserID      uuid.UUID `json:"user_id"`
 	Value       int       `json:"value"` // 1 for upvote, -1 for downvote
+	// Weight multiplies Value; super-votes in credit rooms weigh more than 1.
+	Weight    int       `json:"weight" gorm:"not null;default:1"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Voter is a vote on a queue item with the voter's display name.
+type Voter struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DisplayName string    `json:"display_name"`
+	Value       int       `json:"value"`
+	Weight      int       `json:"weight"`
 	CreatedAt   time.Time `json:"created_at"`
 }
+
+type PlaybackStatus string
+
+const (
+	PlaybackIdle    PlaybackStatus = "idle"
+	PlaybackPlaying PlaybackStatus = "playing"
+	PlaybackPaused  PlaybackStatus = "paused"
+	PlaybackEnded   PlaybackStatus = "ended"
+)
+
+// PlaybackState is the server-authoritative "now playing" state of a room.
+// It is kept in Redis, not MySQL. While playing, the live position is
+// PositionMs plus the time elapsed since ResumedAt.
+type PlaybackState struct {
+	RoomID      uuid.UUID      `json:"room_id"`
+	Status      PlaybackStatus `json:"status"`
+	QueueItemID uuid.UUID      `json:"queue_item_id,omitempty"`
+	TrackID     string         `json:"track_id,omitempty"`
+	TrackName   string         `json:"track_name,omitempty"`
+	Artist      string         `json:"artist,omitempty"`
+	AddedBy     uuid.UUID      `json:"added_by,omitempty"`
+	DurationMs  int            `json:"duration_ms"`
+	StartedAt   time.Time      `json:"started_at"`
+	ResumedAt   time.Time      `json:"resumed_at"`
+	PositionMs  int            `json:"position_ms"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
+
+// Position returns the playback position at now.
+func (p *PlaybackState) Position(now time.Time) int {
+	pos := p.PositionMs
+	if p.Status == PlaybackPlaying {
+		pos += int(now.Sub(p.ResumedAt).Milliseconds())
+	}
+	if pos > p.DurationMs {
+		pos = p.DurationMs
+	}
+	return pos
+}
+
+// EndsAt is when the current track finishes if playback is not interrupted.
+func (p *PlaybackState) EndsAt() time.Time {
+	return p.ResumedAt.Add(time.Duration(p.DurationMs-p.PositionMs) * time.Millisecond)
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrLockHeld = errors.New("lock is held by another owner")
+	ErrLockLost = errors.New("lock is no longer held")
+)
+
+// Only touch the key while it still carries our token.
+var (
+	releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+	refreshScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`)
+)
+
+// Lock is a best-effort mutex shared by every server instance, stored as a
+// single Redis key that expires unless refreshed.
+type Lock struct {
+	client *redis.Client
+	key    string
+	token  string
+	ttl    time.Duration
+}
+
+// TryLock acquires key without waiting. It returns ErrLockHeld when another
+// owner has it.
+func TryLock(ctx context.Context, client *redis.Client, key string, ttl time.Duration) (*Lock, error) {
+	token := uuid.New().String()
+	ok, err := client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	if !ok {
+		return nil, ErrLockHeld
+	}
+
+	return &Lock{client: client, key: key, token: token, ttl: ttl}, nil
+}
+
+// AcquireLock retries TryLock every retryEvery until it succeeds or ctx is done.
+func AcquireLock(ctx context.Context, client *redis.Client, key string, ttl, retryEvery time.Duration) (*Lock, error) {
+	for {
+		lock, err := TryLock(ctx, client, key, ttl)
+		if !errors.Is(err, ErrLockHeld) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryEvery):
+		}
+	}
+}
+
+// Refresh extends the lock by its TTL. It returns ErrLockLost when the key
+// expired or was taken over in the meantime.
+func (l *Lock) Refresh(ctx context.Context) error {
+	n, err := refreshScript.Run(ctx, l.client, []string{l.key}, l.token, l.ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to refresh lock: %w", err)
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (l *Lock) Release(ctx context.Context) error {
+	if err := releaseScript.Run(ctx, l.client, []string{l.key}, l.token).Err(); err != nil {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}